@@ -0,0 +1,481 @@
+package mogo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// Aggregation is the mgo.Pipe wrapper. The results are decoded into registered
+// documents as the Query ones (AfterFind hooks, plugins and NewTracker), use
+// MgoP to get raw results (i.e. bson.M).
+type Aggregation struct {
+	MgoC *mgo.Collection
+	MgoP *mgo.Pipe
+
+	// Pipeline is the aggregation pipeline ([]bson.M, see Stages)
+	Pipeline interface{}
+
+	// options (allowDiskUse, batch size, ...) applied to MgoP, they are applied
+	// again when MgoP is rebuilt
+	options []func(*mgo.Pipe) *mgo.Pipe
+
+	// coll is the Collection which created the aggregation
+	coll *Collection
+
+	// deleted selects the soft deleted documents passed to the pipeline
+	deleted deletedMode
+
+	// err is the error of the pipeline which can not be filtered, returned
+	// by Iter, One, All and Explain
+	err error
+}
+
+// Aggregate is a wrapper to the mgo Pipe. The soft deleted documents of soft
+// delete models are excluded by a $match stage added at the beginning of the
+// pipeline, after the stages which must come first ($geoNear, $search, ...),
+// see WithDeleted. The pipeline must be a slice of stages to be filtered.
+func (c *Collection) Aggregate(pipeline interface{}) *Aggregation {
+	a := &Aggregation{
+		MgoC:     c.C(),
+		Pipeline: pipeline,
+		coll:     c,
+	}
+	a.rebuild()
+
+	return a
+}
+
+// Aggregate is convenience method for Collection.Aggregate
+func Aggregate(doc Document, pipeline interface{}) *Aggregation {
+	return doc.GetColl().Aggregate(pipeline)
+}
+
+// rebuild creates MgoP applying the options
+func (a *Aggregation) rebuild() {
+	var stages interface{}
+
+	stages, a.err = a.stages()
+	if a.err != nil {
+		stages = a.Pipeline
+	}
+
+	a.MgoP = a.MgoC.Pipe(stages)
+	for _, opt := range a.options {
+		a.MgoP = opt(a.MgoP)
+	}
+}
+
+// firstStages are the stages which must be the first of the pipeline, the
+// soft delete $match is added after them
+var firstStages = map[string]bool{
+	"$geoNear":    true,
+	"$collStats":  true,
+	"$indexStats": true,
+	"$search":     true,
+	"$searchMeta": true,
+}
+
+// stages returns the pipeline, excluding (or selecting) the soft deleted
+// documents of soft delete models. It returns an error if the pipeline is
+// not a slice of stages.
+func (a *Aggregation) stages() (interface{}, error) {
+	if a.deleted == withDeleted || !softDeleted(a.connection().Models(), a.MgoC.Name) {
+		return a.Pipeline, nil
+	}
+
+	var p []interface{}
+	if a.Pipeline != nil {
+		v := reflect.ValueOf(a.Pipeline)
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return nil, fmt.Errorf("the pipeline %T is not a slice of stages, the soft deleted documents can not be filtered", a.Pipeline)
+		}
+
+		p = make([]interface{}, v.Len())
+		for i := range p {
+			p[i] = v.Index(i).Interface()
+		}
+	}
+
+	i := 0
+	for i < len(p) && firstStages[stageName(p[i])] {
+		i++
+	}
+
+	stages := make([]interface{}, 0, len(p)+1)
+	stages = append(stages, p[:i]...)
+	stages = append(stages, bson.M{"$match": bson.M{"_deleted": bson.M{"$exists": a.deleted == onlyDeleted}}})
+	stages = append(stages, p[i:]...)
+
+	return stages, nil
+}
+
+// stageName returns the operator of the pipeline stage s (i.e. $match), empty
+// if unknown
+func stageName(s interface{}) string {
+	switch s := s.(type) {
+	case bson.M:
+		for k := range s {
+			return k
+		}
+	case map[string]interface{}:
+		for k := range s {
+			return k
+		}
+	case bson.D:
+		if len(s) > 0 {
+			return s[0].Name
+		}
+	}
+
+	return ""
+}
+
+func (a *Aggregation) apply(opt func(*mgo.Pipe) *mgo.Pipe) *Aggregation {
+	a.options = append(a.options, opt)
+	a.MgoP = opt(a.MgoP)
+
+	return a
+}
+
+// WithDeleted passes also the soft deleted documents to the pipeline
+func (a *Aggregation) WithDeleted() *Aggregation {
+	a.deleted = withDeleted
+	a.rebuild()
+
+	return a
+}
+
+// OnlyDeleted passes only the soft deleted documents to the pipeline
+func (a *Aggregation) OnlyDeleted() *Aggregation {
+	a.deleted = onlyDeleted
+	a.rebuild()
+
+	return a
+}
+
+// AllowDiskUse is a wrapper around mgo.Pipe.AllowDiskUse
+func (a *Aggregation) AllowDiskUse() *Aggregation {
+	return a.apply(func(p *mgo.Pipe) *mgo.Pipe {
+		return p.AllowDiskUse()
+	})
+}
+
+// Batch is a wrapper around mgo.Pipe.Batch
+func (a *Aggregation) Batch(n int) *Aggregation {
+	return a.apply(func(p *mgo.Pipe) *mgo.Pipe {
+		return p.Batch(n)
+	})
+}
+
+// SetMaxTime is a wrapper around mgo.Pipe.SetMaxTime
+func (a *Aggregation) SetMaxTime(d time.Duration) *Aggregation {
+	return a.apply(func(p *mgo.Pipe) *mgo.Pipe {
+		return p.SetMaxTime(d)
+	})
+}
+
+// Explain is a wrapper around mgo.Pipe.Explain, the execution plan is
+// stored in result (i.e. bson.M)
+func (a *Aggregation) Explain(result interface{}) error {
+	if a.err != nil {
+		return a.err
+	}
+
+	return a.MgoP.Explain(result)
+}
+
+// Iter is a wrapper around mgo.Pipe.Iter, the returned iterator decodes the
+// results into registered documents (see Iter.Next)
+func (a *Aggregation) Iter() *Iter {
+	if a.err != nil {
+		return &Iter{Err: a.err, pipe: a}
+	}
+
+	return &Iter{
+		MgoI: a.MgoP.Iter(),
+		pipe: a,
+	}
+}
+
+// One loads the first result of the pipeline in the result document
+func (a *Aggregation) One(result interface{}) error {
+	if _, ok := result.(Document); !ok {
+		panic("result is not a mogo document")
+	}
+
+	iter := a.Iter()
+	found := iter.Next(result)
+
+	err := iter.Close()
+	if iter.Err != nil {
+		return iter.Err
+	}
+	if err != nil {
+		return err
+	}
+	if !found {
+		return mgo.ErrNotFound
+	}
+
+	return nil
+}
+
+// All loads the results in the slice pointed by result ([]T or []*T of a
+// registered document, see Query.All)
+func (a *Aggregation) All(result interface{}) error {
+	iter := a.Iter()
+	iter.fill(result)
+
+	err := iter.Close()
+	if iter.Err != nil {
+		return iter.Err
+	}
+
+	return err
+}
+
+// operation returns the Operation loading doc with the aggregation
+func (a *Aggregation) operation(doc Document) *Operation {
+	op := &Operation{
+		Hook: &HookContext{Collection: a.MgoC, Op: OpFind},
+		Doc:  doc,
+	}
+	op.Model, _ = doc.GetMe()
+
+	if a.coll != nil {
+		op.Hook.Context = a.coll.Context
+	}
+
+	return op
+}
+
+// connection returns the Connection of the aggregation, nil if unknown
+func (a *Aggregation) connection() *Connection {
+	if a == nil || a.coll == nil {
+		return nil
+	}
+
+	return a.coll.Connection
+}
+
+// bindingFor returns the binding of the document d loaded by the aggregation:
+// the connection and database of the aggregation. The results can be documents
+// of any model, so they are bound to their model collection.
+func (a *Aggregation) bindingFor(d Document) docBinding {
+	if a == nil || a.coll == nil {
+		return bindingOf(d)
+	}
+
+	return docBinding{conn: a.coll.Connection, db: a.coll.Database}
+}
+
+// Stages is an aggregation pipeline builder using the struct field names of the
+// model instead of the bson ones, as Criteria. Field names are validated and
+// converted to bson names (see GetBsonPath) when the pipeline is built for a
+// registered model, i.e.:
+//
+//	p, err := mogo.NewStages().
+//		Match(mogo.Where("Age").Gt(18)).
+//		Group("Gender", mogo.Count("total"), mogo.AvgOf("age", "Age")).
+//		Build(Person{})
+//	if err == nil {
+//		err = person.GetColl().Aggregate(p).MgoP.All(&stats)
+//	}
+//
+// The field names refer to the model fields, so the stages following a stage
+// reshaping the documents ($group, $project) must be added with Stage.
+type Stages struct {
+	stages []func(ri *ModelInternals) (bson.M, error)
+}
+
+// Accumulator is an accumulator of the $group stage (see Group, SumOf, AvgOf,
+// MinOf, MaxOf, FirstOf, LastOf, PushOf and Count)
+type Accumulator struct {
+	// Name is the name of the output field
+	Name string
+	// Op is the accumulator operator (i.e. $sum)
+	Op string
+	// Field is the model field passed to the operator, if empty Value is used
+	Field string
+	// Value is the expression passed to the operator when Field is empty
+	Value interface{}
+}
+
+// NewStages starts a new pipeline
+func NewStages() *Stages {
+	return &Stages{}
+}
+
+// Match adds a $match stage with the Criteria filter
+func (s *Stages) Match(c *Criteria) *Stages {
+	return s.add(func(ri *ModelInternals) (bson.M, error) {
+		q, err := c.build(ri)
+		return bson.M{"$match": q}, err
+	})
+}
+
+// Sort adds a $sort stage, fields prefixed by - are sorted in descending order
+func (s *Stages) Sort(fields ...string) *Stages {
+	return s.add(func(ri *ModelInternals) (bson.M, error) {
+		sort := bson.D{}
+		for _, f := range fields {
+			order := 1
+			if strings.HasPrefix(f, "-") {
+				f, order = f[1:], -1
+			}
+
+			p, err := GetBsonPath(ri.Type, f)
+			if err != nil {
+				return nil, err
+			}
+			sort = append(sort, bson.DocElem{Name: p, Value: order})
+		}
+
+		return bson.M{"$sort": sort}, nil
+	})
+}
+
+// Project adds a $project stage including the passed fields
+func (s *Stages) Project(fields ...string) *Stages {
+	return s.add(func(ri *ModelInternals) (bson.M, error) {
+		project := bson.M{}
+		for _, f := range fields {
+			p, err := GetBsonPath(ri.Type, f)
+			if err != nil {
+				return nil, err
+			}
+			project[p] = 1
+		}
+
+		return bson.M{"$project": project}, nil
+	})
+}
+
+// Unwind adds an $unwind stage of the passed slice field
+func (s *Stages) Unwind(field string) *Stages {
+	return s.add(func(ri *ModelInternals) (bson.M, error) {
+		p, err := GetBsonPath(ri.Type, field)
+		return bson.M{"$unwind": "$" + p}, err
+	})
+}
+
+// Group adds a $group stage grouping by the passed field (all the documents
+// if empty) and computing the accumulators
+func (s *Stages) Group(field string, accumulators ...Accumulator) *Stages {
+	return s.add(func(ri *ModelInternals) (bson.M, error) {
+		group := bson.M{"_id": nil}
+		if field != "" {
+			p, err := GetBsonPath(ri.Type, field)
+			if err != nil {
+				return nil, err
+			}
+			group["_id"] = "$" + p
+		}
+
+		for _, acc := range accumulators {
+			if acc.Name == "" || acc.Name == "_id" {
+				return nil, fmt.Errorf("bad accumulator name %q", acc.Name)
+			}
+
+			v := acc.Value
+			if acc.Field != "" {
+				p, err := GetBsonPath(ri.Type, acc.Field)
+				if err != nil {
+					return nil, err
+				}
+				v = "$" + p
+			}
+			group[acc.Name] = bson.M{acc.Op: v}
+		}
+
+		return bson.M{"$group": group}, nil
+	})
+}
+
+// Limit adds a $limit stage
+func (s *Stages) Limit(n int) *Stages {
+	return s.Stage(bson.M{"$limit": n})
+}
+
+// Skip adds a $skip stage
+func (s *Stages) Skip(n int) *Stages {
+	return s.Stage(bson.M{"$skip": n})
+}
+
+// Stage adds a stage as it is, without field names conversion
+func (s *Stages) Stage(stage bson.M) *Stages {
+	return s.add(func(ri *ModelInternals) (bson.M, error) {
+		return stage, nil
+	})
+}
+
+func (s *Stages) add(stage func(ri *ModelInternals) (bson.M, error)) *Stages {
+	s.stages = append(s.stages, stage)
+	return s
+}
+
+// Build returns the pipeline for the passed model, it can be used directly with
+// the Aggregate methods. An error is returned if the model is not registered or
+// if a field does not exist in the model.
+func (s *Stages) Build(model interface{}) ([]bson.M, error) {
+	n, ri, ok := registryOf(model).Exists(model)
+	if !ok {
+		return nil, fmt.Errorf("model %T is not registered", model)
+	}
+
+	pipeline := make([]bson.M, 0, len(s.stages))
+	for i, stage := range s.stages {
+		st, err := stage(ri)
+		if err != nil {
+			return nil, fmt.Errorf("%s (model: %s, stage: %d)", err.Error(), n, i)
+		}
+		pipeline = append(pipeline, st)
+	}
+
+	return pipeline, nil
+}
+
+// SumOf returns the $sum accumulator of the field
+func SumOf(name string, field string) Accumulator {
+	return Accumulator{Name: name, Op: "$sum", Field: field}
+}
+
+// AvgOf returns the $avg accumulator of the field
+func AvgOf(name string, field string) Accumulator {
+	return Accumulator{Name: name, Op: "$avg", Field: field}
+}
+
+// MinOf returns the $min accumulator of the field
+func MinOf(name string, field string) Accumulator {
+	return Accumulator{Name: name, Op: "$min", Field: field}
+}
+
+// MaxOf returns the $max accumulator of the field
+func MaxOf(name string, field string) Accumulator {
+	return Accumulator{Name: name, Op: "$max", Field: field}
+}
+
+// FirstOf returns the $first accumulator of the field
+func FirstOf(name string, field string) Accumulator {
+	return Accumulator{Name: name, Op: "$first", Field: field}
+}
+
+// LastOf returns the $last accumulator of the field
+func LastOf(name string, field string) Accumulator {
+	return Accumulator{Name: name, Op: "$last", Field: field}
+}
+
+// PushOf returns the $push accumulator of the field
+func PushOf(name string, field string) Accumulator {
+	return Accumulator{Name: name, Op: "$push", Field: field}
+}
+
+// Count returns the accumulator counting the documents of the group
+func Count(name string) Accumulator {
+	return Accumulator{Name: name, Op: "$sum", Value: 1}
+}
@@ -0,0 +1,162 @@
+package mogo
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type Sale struct {
+	DocumentModel `bson:",inline" coll:"sales"`
+	Item          string `bson:"item"`
+	Qty           int    `bson:"quantity"`
+	Shop          SaleShop
+
+	found int
+}
+
+type SaleShop struct {
+	City string `bson:"town"`
+}
+
+func (s *Sale) AfterFind() error {
+	s.found++
+	return nil
+}
+
+func TestStages(t *testing.T) {
+	Convey("Stages should resolve the field names of the model", t, func() {
+		ModelRegistry.Register(Sale{}, Note{})
+
+		p, err := NewStages().
+			Match(Where("Item").Eq("a")).
+			Sort("-Qty", "Shop.City").
+			Group("Shop.City", SumOf("total", "Qty"), Count("sales"), MaxOf("max", "Qty")).
+			Stage(bson.M{"$sort": bson.M{"total": -1}}).
+			Limit(3).
+			Build(Sale{})
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, []bson.M{
+			{"$match": bson.M{"item": bson.M{"$eq": "a"}}},
+			{"$sort": bson.D{{Name: "quantity", Value: -1}, {Name: "shop.town", Value: 1}}},
+			{"$group": bson.M{
+				"_id":   "$shop.town",
+				"total": bson.M{"$sum": "$quantity"},
+				"sales": bson.M{"$sum": 1},
+				"max":   bson.M{"$max": "$quantity"},
+			}},
+			{"$sort": bson.M{"total": -1}},
+			{"$limit": 3},
+		})
+
+		p, err = NewStages().Project("Item").Unwind("Shop").Group("").Build(Sale{})
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, []bson.M{
+			{"$project": bson.M{"item": 1}},
+			{"$unwind": "$shop"},
+			{"$group": bson.M{"_id": nil}},
+		})
+
+		_, err = NewStages().Sort("Price").Build(Sale{})
+		So(err, ShouldNotBeNil)
+		_, err = NewStages().Group("Item", SumOf("", "Qty")).Build(Sale{})
+		So(err, ShouldNotBeNil)
+		_, err = NewStages().Build(BadDocument{})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Aggregation should exclude the soft deleted documents", t, func() {
+		a := &Aggregation{MgoC: &mgo.Collection{Name: "notes"}, Pipeline: []bson.M{{"$limit": 1}}}
+		stages, err := a.stages()
+		So(err, ShouldBeNil)
+		So(stages, ShouldResemble, []interface{}{
+			bson.M{"$match": bson.M{"_deleted": bson.M{"$exists": false}}},
+			bson.M{"$limit": 1},
+		})
+
+		a.deleted = withDeleted
+		stages, err = a.stages()
+		So(err, ShouldBeNil)
+		So(stages, ShouldResemble, []bson.M{{"$limit": 1}})
+
+		a = &Aggregation{MgoC: &mgo.Collection{Name: "sales"}, Pipeline: []bson.M{{"$limit": 1}}}
+		stages, err = a.stages()
+		So(err, ShouldBeNil)
+		So(stages, ShouldResemble, []bson.M{{"$limit": 1}})
+
+		// the stages which must be the first ones stay at the beginning
+		near := bson.D{{Name: "$geoNear", Value: bson.M{"near": []float64{0, 0}}}}
+		a = &Aggregation{MgoC: &mgo.Collection{Name: "notes"}, Pipeline: []interface{}{near, bson.M{"$limit": 1}}}
+		a.deleted = onlyDeleted
+		stages, err = a.stages()
+		So(err, ShouldBeNil)
+		So(stages, ShouldResemble, []interface{}{
+			near,
+			bson.M{"$match": bson.M{"_deleted": bson.M{"$exists": true}}},
+			bson.M{"$limit": 1},
+		})
+
+		a = &Aggregation{MgoC: &mgo.Collection{Name: "notes"}, Pipeline: bson.M{"$limit": 1}}
+		_, err = a.stages()
+		So(err, ShouldNotBeNil)
+		a.err = err
+		So(a.Iter().Next(NewDoc(Note{})), ShouldBeFalse)
+		So(a.Iter().Err, ShouldEqual, err)
+		So(a.Explain(&bson.M{}), ShouldEqual, err)
+	})
+}
+
+func TestAggregate(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	ModelRegistry.Register(Sale{})
+
+	Convey("Aggregate", t, func() {
+		conn.Session.DB("mogotest").C("sales").RemoveAll(nil)
+		for i, item := range []string{"a", "b", "a", "c"} {
+			s := NewDoc(Sale{Item: item, Qty: i + 1, Shop: SaleShop{City: "x"}}).(*Sale)
+			So(Save(s), ShouldBeNil)
+		}
+
+		Convey("should decode the results into documents running the hooks", func() {
+			p, err := NewStages().Match(Where("Item").Eq("a")).Sort("-Qty").Build(Sale{})
+			So(err, ShouldBeNil)
+
+			sales := []*Sale{}
+			So(Aggregate(NewDoc(Sale{}).(*Sale), p).AllowDiskUse().Batch(1).All(&sales), ShouldBeNil)
+			So(len(sales), ShouldEqual, 2)
+			So(sales[0].Qty, ShouldEqual, 3)
+			So(sales[0].found, ShouldEqual, 1)
+			So(sales[0].IsNew(), ShouldBeFalse)
+			So(sales[0].GetColl().Name, ShouldEqual, "sales")
+
+			s := NewDoc(Sale{}).(*Sale)
+			So(conn.Collection("sales").Aggregate(p).One(s), ShouldBeNil)
+			So(s.Qty, ShouldEqual, 3)
+			So(s.found, ShouldEqual, 1)
+
+			p, _ = NewStages().Match(Where("Item").Eq("z")).Build(Sale{})
+			So(conn.Collection("sales").Aggregate(p).One(s), ShouldEqual, mgo.ErrNotFound)
+		})
+
+		Convey("should give access to raw results and explain", func() {
+			p, err := NewStages().Group("Item", SumOf("total", "Qty")).Stage(bson.M{"$sort": bson.M{"_id": 1}}).Build(Sale{})
+			So(err, ShouldBeNil)
+
+			stats := []bson.M{}
+			So(conn.Collection("sales").Aggregate(p).MgoP.All(&stats), ShouldBeNil)
+			So(stats, ShouldResemble, []bson.M{
+				{"_id": "a", "total": 4},
+				{"_id": "b", "total": 2},
+				{"_id": "c", "total": 4},
+			})
+
+			plan := bson.M{}
+			So(conn.Collection("sales").Aggregate(p).Explain(&plan), ShouldBeNil)
+			So(plan, ShouldNotBeEmpty)
+		})
+	})
+}
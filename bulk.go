@@ -0,0 +1,591 @@
+package mogo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// ErrBulkFailed is returned by Bulk.Run when some of the queued writes failed,
+// the error of each document is reported in BulkResult.Items
+var ErrBulkFailed = errors.New("bulk write failed")
+
+// ErrBulkNotRun is the error of the writes of an ordered bulk following a
+// failed write, which are not executed
+var ErrBulkNotRun = errors.New("bulk write not executed after a failed write")
+
+// bulkBatch is the maximum number of writes sent in a single mgo.Bulk, and so
+// the maximum number of pipelines waiting for their write
+const bulkBatch = 1000
+
+// Bulk queues the writes of documents stored in a collection and executes
+// them together through mgo.Bulk. Each write goes through the plugin pipeline
+// as Save and Remove do: the validation and before hooks of the documents run
+// before the writes are sent, the after hooks run only for the documents
+// written successfully.
+//
+// The writes are sent in batches of up to 1000 documents. The pipeline of
+// each document of a batch waits for the write in its own goroutine, the
+// pipelines run one at a time in the queued order.
+type Bulk struct {
+	coll    *Collection
+	ops     []*bulkOp
+	ordered bool
+}
+
+// BulkResult is the result of Bulk.Run
+type BulkResult struct {
+	// Matched and Modified are the documents matched and modified by the
+	// updates, upserts and removals. The driver does not report them when
+	// some write fails.
+	Matched  int
+	Modified int
+
+	// Items holds the result of each queued write, in the queued order. A
+	// document queued more than once has an item for each write (see Errors).
+	Items []BulkItem
+}
+
+// BulkItem is the result of the write of a document
+type BulkItem struct {
+	// ID is the id of the document, empty if an update or a removal was
+	// queued without a valid id
+	ID bson.ObjectId
+
+	// Err is the error of the write, nil if the document was written
+	Err error
+}
+
+// Errors maps the id of the documents not written to the error of their write.
+// A document queued more than once is mapped to the error of its first failed
+// write.
+func (r *BulkResult) Errors() map[bson.ObjectId]error {
+	errs := make(map[bson.ObjectId]error)
+	for _, item := range r.Items {
+		if _, ok := errs[item.ID]; !ok && item.Err != nil {
+			errs[item.ID] = item.Err
+		}
+	}
+
+	return errs
+}
+
+// bulkKind is the kind of a queued write
+type bulkKind int
+
+const (
+	bulkInsert bulkKind = iota
+	bulkUpsert
+	bulkUpdate
+	bulkRemove
+)
+
+// bulkOp is a queued write and its prepared state
+type bulkOp struct {
+	kind bulkKind
+	doc  Document
+
+	// err is the error of the pipeline of the write
+	err error
+
+	// prepared state
+	skip      bool
+	checked   bool
+	selector  bson.M
+	update    interface{}
+	versioned bool
+	version   int
+	modified  time.Time
+	soft      bool
+	deleted   time.Time
+
+	// The pipeline of the write runs in its own goroutine and waits in the
+	// core, while the other documents of the batch are prepared, until the
+	// writes are executed
+	queued   chan bool
+	release  chan struct{}
+	done     chan struct{}
+	werr     error
+	panicked interface{}
+}
+
+// Bulk returns a Bulk writing documents of the collection in ordered mode:
+// the writes are executed in the queued order, stopping at the first failure
+// (see Unordered).
+func (c *Collection) Bulk() *Bulk {
+	return &Bulk{coll: c, ordered: true}
+}
+
+// Unordered puts the bulk in unordered mode, the writes can be executed in any
+// order and the failure of a write doesn't stop the following ones
+func (b *Bulk) Unordered() *Bulk {
+	b.ordered = false
+
+	return b
+}
+
+// Insert queues the documents to be inserted, the write fails if a document
+// with the same id exists
+func (b *Bulk) Insert(docs ...Document) *Bulk {
+	return b.queue(bulkInsert, docs)
+}
+
+// Upsert queues the documents to be saved as Collection.Save does: new
+// documents are inserted, the stored ones are replaced (or updated with the
+// modified fields for Trackable documents)
+func (b *Bulk) Upsert(docs ...Document) *Bulk {
+	return b.queue(bulkUpsert, docs)
+}
+
+// Update queues the documents to be updated. Documents not found are not
+// reported as failures but are not counted in BulkResult.Matched. The stored
+// documents of versioned models are updated only at the loaded version, as
+// Save does, and fail with ErrVersionConflict otherwise.
+func (b *Bulk) Update(docs ...Document) *Bulk {
+	return b.queue(bulkUpdate, docs)
+}
+
+// Remove queues the documents to be removed, as Collection.Remove does (the
+// documents of soft delete models are marked as deleted, and fail with
+// mgo.ErrNotFound if they are already deleted)
+func (b *Bulk) Remove(docs ...Document) *Bulk {
+	return b.queue(bulkRemove, docs)
+}
+
+// queue appends the writes of docs. The new documents inserted or upserted
+// without id get one, so every document is identified in BulkResult.Items.
+func (b *Bulk) queue(kind bulkKind, docs []Document) *Bulk {
+	for _, d := range docs {
+		newt, ok := d.(NewTracker)
+		if (kind == bulkInsert || kind == bulkUpsert) && !d.GetID().Valid() && (!ok || newt.IsNew()) {
+			d.SetID(bson.NewObjectId())
+		}
+
+		b.ops = append(b.ops, &bulkOp{kind: kind, doc: d})
+	}
+
+	return b
+}
+
+// Run prepares the queued documents (validation, before hooks, times and
+// versions) and executes the writes. A document failing the preparation is not
+// written and, in ordered mode, the following ones are not prepared nor written
+// (ErrBulkNotRun). It returns ErrBulkFailed if some write failed.
+//
+// The version conflicts and the soft removals of documents already deleted are
+// found after their batch is written: in ordered mode they stop the following
+// batches, but not the following writes of the same batch.
+func (b *Bulk) Run() (*BulkResult, error) {
+	sess := b.coll.Connection.Session.Clone()
+	defer sess.Close()
+	col := b.coll.collectionOnSession(sess)
+
+	res := &BulkResult{Items: make([]BulkItem, len(b.ops))}
+	stopped := false
+	var panicked interface{}
+	for i := 0; i < len(b.ops); i += bulkBatch {
+		ops := b.ops[i:]
+		if len(ops) > bulkBatch {
+			ops = ops[:bulkBatch]
+		}
+
+		if stopped {
+			for _, op := range ops {
+				op.err = ErrBulkNotRun
+			}
+			continue
+		}
+
+		panicked = b.run(sess, col, ops, res)
+		stopped = panicked != nil
+		for _, op := range ops {
+			stopped = stopped || b.ordered && op.err != nil
+		}
+	}
+
+	if panicked != nil {
+		panic(panicked)
+	}
+
+	var err error
+	for i, op := range b.ops {
+		res.Items[i] = BulkItem{ID: op.doc.GetID(), Err: op.err}
+		if op.err != nil {
+			err = ErrBulkFailed
+		}
+	}
+
+	return res, err
+}
+
+// run executes a batch of writes, returning the value of the panic of a
+// pipeline if any
+func (b *Bulk) run(sess *mgo.Session, col *mgo.Collection, ops []*bulkOp, res *BulkResult) interface{} {
+	queued := []*bulkOp{}
+	stopped := false
+	var panicked interface{}
+	for _, op := range ops {
+		if stopped {
+			op.err = ErrBulkNotRun
+			continue
+		}
+
+		if b.start(sess, col, op) {
+			queued = append(queued, op)
+			continue
+		}
+
+		if op.panicked != nil {
+			panicked = op.panicked
+			stopped = true
+			continue
+		}
+		stopped = b.ordered && op.err != nil
+	}
+
+	if panicked == nil {
+		b.write(col, queued, res)
+	} else {
+		for _, op := range queued {
+			op.werr = ErrBulkNotRun
+		}
+	}
+
+	// The pipelines complete the writes and run the after hooks in order
+	for _, op := range queued {
+		close(op.release)
+		<-op.done
+
+		if panicked == nil {
+			panicked = op.panicked
+		}
+	}
+
+	return panicked
+}
+
+// start runs the pipeline of op until its write is queued, returning true, or
+// until the pipeline ends without writing (i.e. the validation failed)
+func (b *Bulk) start(sess *mgo.Session, col *mgo.Collection, op *bulkOp) bool {
+	if c := op.doc.GetColl(); c.Name != b.coll.Name || c.Database != b.coll.Database {
+		op.err = fmt.Errorf("the document is not stored in the bulk collection %s", b.coll.Name)
+		return false
+	}
+
+	op.queued = make(chan bool, 1)
+	op.release = make(chan struct{})
+	op.done = make(chan struct{})
+
+	kind := OpSave
+	if op.kind == bulkRemove {
+		kind = OpRemove
+	}
+
+	go func() {
+		waiting := false
+		defer func() {
+			if r := recover(); r != nil {
+				op.panicked = r
+			}
+			if !waiting {
+				op.queued <- false
+			}
+			close(op.done)
+		}()
+
+		op.err = runPipeline(b.coll.Connection, b.coll.operation(col, kind, op.doc), func(pop *Operation) error {
+			op.doc = pop.Doc
+
+			err := b.prepare(sess, op)
+			if err != nil {
+				op.restore()
+				return err
+			}
+
+			waiting = true
+			op.queued <- true
+			<-op.release
+
+			return b.written(sess, op)
+		})
+	}()
+
+	if <-op.queued {
+		return true
+	}
+	<-op.done
+
+	return false
+}
+
+// prepare builds the write of op, setting ids, times and versions as
+// Collection.Save and Collection.Remove do (the hooks are run by the pipeline)
+func (b *Bulk) prepare(sess *mgo.Session, op *bulkOp) error {
+	doc := op.doc
+
+	// Times are stored with millisecond precision, checkWrites compares them
+	// with the stored ones
+	now := bson.Now()
+
+	id := doc.GetID()
+	if op.kind == bulkRemove {
+		if !id.Valid() {
+			return errors.New("the document to remove has no valid Id field")
+		}
+
+		op.soft = isSoftDelete(doc)
+		if op.soft {
+			// the document must not be deleted yet
+			op.checked = true
+			op.deleted = now
+			op.selector = bson.M{"_id": id, "_deleted": bson.M{"$exists": false}}
+			op.update = stampedUpdate(doc, bson.M{"$set": bson.M{"_deleted": now}}, now)
+		}
+
+		return checkRestrict(sess, doc, !op.soft)
+	}
+
+	isNew := true
+	if newt, ok := doc.(NewTracker); ok {
+		isNew = newt.IsNew()
+	}
+
+	if isNew && !id.Valid() && op.kind != bulkUpdate {
+		id = bson.NewObjectId()
+		doc.SetID(id)
+	}
+
+	if !id.Valid() {
+		return errors.New("the document to update has no valid Id field")
+	}
+
+	if tt, ok := doc.(TimeCreatedTracker); ok && isNew {
+		tt.SetCreated(now)
+	}
+
+	if tt, ok := doc.(TimeModifiedTracker); ok {
+		tt.SetModified(now)
+		op.modified = now
+	}
+
+	vt, versioned := doc.(VersionTracker)
+	if versioned {
+		_, ri, ok := registryOf(doc).Exists(doc)
+		op.versioned = ok && ri.Versioned
+	}
+
+	if op.versioned {
+		op.version = vt.GetVersion()
+		vt.SetVersion(op.version + 1)
+	}
+
+	if op.kind == bulkInsert {
+		return nil
+	}
+
+	op.selector = bson.M{"_id": id}
+	if op.versioned && !isNew {
+		// stored versioned documents are updated only at the loaded version
+		op.kind = bulkUpdate
+		op.selector = versionSelector(id, op.version)
+		op.checked = true
+	}
+
+	op.update = doc
+	if tracker, ok := doc.(Trackable); ok && !isNew {
+		diff, err := tracker.GetDiffTracker().BsonUpdate()
+		if err != nil {
+			return err
+		}
+
+		if diff != nil {
+			op.update = diff
+			op.skip = len(diff) == 0
+		}
+	}
+
+	return nil
+}
+
+// restore sets back the version of the document of op, which was not written
+func (op *bulkOp) restore() {
+	if op.versioned {
+		op.doc.(VersionTracker).SetVersion(op.version)
+	}
+}
+
+// write executes the queued writes in a single mgo.Bulk, setting their errors
+func (b *Bulk) write(col *mgo.Collection, queued []*bulkOp, res *BulkResult) {
+	var sent []*bulkOp
+	for _, op := range queued {
+		if !op.skip {
+			sent = append(sent, op)
+		}
+	}
+
+	if len(sent) == 0 {
+		return
+	}
+
+	mb := col.Bulk()
+	if !b.ordered {
+		mb.Unordered()
+	}
+	for _, op := range sent {
+		op.send(mb)
+	}
+
+	r, err := mb.Run()
+	if r != nil {
+		res.Matched += r.Matched
+		res.Modified += r.Modified
+	}
+	if err != nil {
+		b.failed(sent, err)
+	}
+
+	b.checkWrites(col, sent, r)
+}
+
+// send queues the write of op in mb
+func (op *bulkOp) send(mb *mgo.Bulk) {
+	switch {
+	case op.kind == bulkInsert:
+		mb.Insert(op.doc)
+	case op.kind == bulkUpsert:
+		mb.Upsert(op.selector, op.update)
+	case op.kind == bulkUpdate || op.soft:
+		mb.Update(op.selector, op.update)
+	default:
+		mb.Remove(bson.M{"_id": op.doc.GetID()})
+	}
+}
+
+// bulkStored is the state of a stored document checked by checkWrites
+type bulkStored struct {
+	ID       bson.ObjectId `bson:"_id"`
+	Version  int           `bson:"_version"`
+	Modified time.Time     `bson:"_modified"`
+	Deleted  time.Time     `bson:"_deleted"`
+}
+
+// checkWrites sets the errors of the versioned updates and of the soft removals
+// which did not match their document. The driver reports only the total of the
+// matched documents (r), so when it is less than the sent updates and removals
+// the stored documents are loaded, to check which ones have the written
+// version and modified time, or deleted time.
+func (b *Bulk) checkWrites(col *mgo.Collection, sent []*bulkOp, r *mgo.BulkResult) {
+	var checked []*bulkOp
+	var ids []bson.ObjectId
+	expected := 0
+	for _, op := range sent {
+		if op.kind != bulkInsert {
+			expected++
+		}
+		if op.checked && op.werr == nil {
+			checked = append(checked, op)
+			ids = append(ids, op.doc.GetID())
+		}
+	}
+
+	if len(checked) == 0 || r != nil && r.Matched == expected {
+		return
+	}
+
+	stored := make(map[bson.ObjectId]bulkStored)
+	iter := col.Find(bson.M{"_id": bson.M{"$in": ids}}).
+		Select(bson.M{"_version": 1, "_modified": 1, "_deleted": 1}).Iter()
+	for {
+		var s bulkStored
+		if !iter.Next(&s) {
+			break
+		}
+		stored[s.ID] = s
+	}
+	err := iter.Close()
+
+	for _, op := range checked {
+		s, ok := stored[op.doc.GetID()]
+		switch {
+		case err != nil:
+			op.werr = err
+		case op.soft:
+			if !ok || !s.Deleted.Equal(op.deleted) {
+				op.werr = mgo.ErrNotFound
+			}
+		case !ok || s.Version != op.version+1 || !s.Modified.Equal(op.modified):
+			op.werr = ErrVersionConflict
+		}
+	}
+}
+
+// failed sets the errors of the batched writes reported by the mgo.Bulk error.
+// In ordered mode the writes following the first failure are not executed.
+func (b *Bulk) failed(sent []*bulkOp, err error) {
+	berr, ok := err.(*mgo.BulkError)
+	if !ok {
+		for _, op := range sent {
+			op.werr = err
+		}
+		return
+	}
+
+	first := len(sent)
+	var unknown error
+	for _, c := range berr.Cases() {
+		// the position is unknown with old servers
+		if c.Index < 0 || c.Index >= len(sent) {
+			unknown = c.Err
+			continue
+		}
+
+		sent[c.Index].werr = c.Err
+		if c.Index < first {
+			first = c.Index
+		}
+	}
+
+	for i, op := range sent {
+		switch {
+		case op.werr != nil:
+		case unknown != nil:
+			op.werr = unknown
+		case b.ordered && i > first:
+			op.werr = ErrBulkNotRun
+		}
+	}
+}
+
+// written completes the write of op in its pipeline, the after hooks run
+// when it returns nil
+func (b *Bulk) written(sess *mgo.Session, op *bulkOp) error {
+	doc := op.doc
+	if op.werr != nil {
+		op.restore()
+		return op.werr
+	}
+
+	if op.kind == bulkRemove {
+		if op.soft {
+			if sd, ok := doc.(SoftDeleteTracker); ok {
+				sd.SetDeleted(&op.deleted)
+			}
+			stamped(doc, op.deleted)
+			return nil
+		}
+
+		return applyOnDelete(sess, doc)
+	}
+
+	if tracker, ok := doc.(Trackable); ok {
+		tracker.GetDiffTracker().Reset()
+	}
+	if newt, ok := doc.(NewTracker); ok {
+		newt.SetIsNew(false)
+	}
+
+	return nil
+}
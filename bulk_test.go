@@ -0,0 +1,212 @@
+package mogo
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type Ticket struct {
+	DocumentModel `bson:",inline" coll:"tickets"`
+	Title         string `validate:"required"`
+
+	saved   int
+	removed int
+}
+
+func (t *Ticket) AfterSave() error {
+	t.saved++
+	return nil
+}
+
+func (t *Ticket) AfterDelete() error {
+	t.removed++
+	return nil
+}
+
+func TestBulk(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	ModelRegistry.Register(Ticket{}, Note{}, Notebook{}, versionedDocument{})
+
+	Convey("Bulk", t, func() {
+		col := conn.Session.DB("mogotest").C("tickets")
+		col.DropCollection()
+
+		a := NewDoc(Ticket{Title: "a"}).(*Ticket)
+		So(Save(a), ShouldBeNil)
+		a.saved = 0
+
+		Convey("should write all the queued documents", func() {
+			b := NewDoc(Ticket{Title: "b"}).(*Ticket)
+			c := NewDoc(Ticket{Title: "c"}).(*Ticket)
+			a.Title = "a2"
+
+			res, err := a.GetColl().Bulk().Insert(b).Upsert(c).Update(a).Run()
+			So(err, ShouldBeNil)
+			So(res.Items, ShouldResemble, []BulkItem{{ID: b.ID}, {ID: c.ID}, {ID: a.ID}})
+			So(res.Errors(), ShouldBeEmpty)
+			So(res.Matched, ShouldEqual, 1)
+			So(b.IsNew(), ShouldBeFalse)
+			So(a.saved+b.saved+c.saved, ShouldEqual, 3)
+
+			n, _ := col.Find(nil).Count()
+			So(n, ShouldEqual, 3)
+			n, _ = col.Find(bson.M{"title": "a2"}).Count()
+			So(n, ShouldEqual, 1)
+
+			res, err = a.GetColl().Bulk().Remove(a, b).Run()
+			So(err, ShouldBeNil)
+			So(res.Matched, ShouldEqual, 2)
+			So(a.removed+b.removed, ShouldEqual, 2)
+			n, _ = col.Find(nil).Count()
+			So(n, ShouldEqual, 1)
+		})
+
+		Convey("should not write the invalid documents", func() {
+			b := NewDoc(Ticket{}).(*Ticket)
+			c := NewDoc(Ticket{Title: "c"}).(*Ticket)
+
+			res, err := a.GetColl().Bulk().Unordered().Insert(b, c).Run()
+			So(err, ShouldEqual, ErrBulkFailed)
+			So(res.Items[0].ID, ShouldEqual, b.ID)
+			So(res.Items[0].Err, ShouldNotBeNil)
+			So(res.Items[1], ShouldResemble, BulkItem{ID: c.ID})
+			So(res.Errors(), ShouldContainKey, b.ID)
+			So(b.saved, ShouldEqual, 0)
+			So(c.saved, ShouldEqual, 1)
+
+			n, _ := col.Find(nil).Count()
+			So(n, ShouldEqual, 2)
+		})
+
+		Convey("should stop at the first failure in ordered mode", func() {
+			dup := NewDoc(Ticket{Title: "dup"}).(*Ticket)
+			dup.SetID(a.ID)
+			b := NewDoc(Ticket{Title: "b"}).(*Ticket)
+			c := NewDoc(Ticket{}).(*Ticket)
+			d := NewDoc(Ticket{Title: "d"}).(*Ticket)
+
+			res, err := a.GetColl().Bulk().Insert(b, dup, d).Run()
+			So(err, ShouldEqual, ErrBulkFailed)
+			So(res.Items[0].Err, ShouldBeNil)
+			So(res.Items[1].ID, ShouldEqual, a.ID)
+			So(res.Items[1].Err, ShouldNotBeNil)
+			So(res.Items[2], ShouldResemble, BulkItem{ID: d.ID, Err: ErrBulkNotRun})
+			So(b.saved, ShouldEqual, 1)
+			So(d.saved, ShouldEqual, 0)
+
+			res, err = a.GetColl().Bulk().Insert(c, d).Run()
+			So(err, ShouldEqual, ErrBulkFailed)
+			So(res.Items[1].Err, ShouldEqual, ErrBulkNotRun)
+
+			n, _ := col.Find(nil).Count()
+			So(n, ShouldEqual, 2)
+		})
+
+		Convey("should send the writes in batches", func() {
+			docs := make([]Document, bulkBatch+1)
+			for i := range docs {
+				docs[i] = NewDoc(Ticket{Title: "t"}).(*Ticket)
+			}
+
+			res, err := a.GetColl().Bulk().Insert(docs...).Run()
+			So(err, ShouldBeNil)
+			So(len(res.Items), ShouldEqual, bulkBatch+1)
+			So(docs[bulkBatch].(*Ticket).saved, ShouldEqual, 1)
+			n, _ := col.Find(nil).Count()
+			So(n, ShouldEqual, bulkBatch+2)
+
+			dup := NewDoc(Ticket{Title: "dup"}).(*Ticket)
+			dup.SetID(a.ID)
+			last := NewDoc(Ticket{Title: "last"}).(*Ticket)
+			docs = append([]Document{dup}, docs[1:bulkBatch]...)
+			res, err = a.GetColl().Bulk().Insert(docs...).Upsert(last).Run()
+			So(err, ShouldEqual, ErrBulkFailed)
+			So(res.Items[bulkBatch], ShouldResemble, BulkItem{ID: last.ID, Err: ErrBulkNotRun})
+			So(last.saved, ShouldEqual, 0)
+		})
+
+		Convey("should report the documents of other collections", func() {
+			note := NewDoc(Note{Text: "a"}).(*Note)
+			res, err := a.GetColl().Bulk().Upsert(note).Run()
+			So(err, ShouldEqual, ErrBulkFailed)
+			So(res.Items[0].Err, ShouldNotBeNil)
+		})
+
+		Convey("should soft delete the documents of soft delete models", func() {
+			conn.Session.DB("mogotest").C("notes").RemoveAll(nil)
+			n1 := NewDoc(Note{Text: "a"}).(*Note)
+			n2 := NewDoc(Note{Text: "b"}).(*Note)
+			So(Save(n1), ShouldBeNil)
+			So(Save(n2), ShouldBeNil)
+
+			_, err := n1.GetColl().Bulk().Remove(n1, n2).Run()
+			So(err, ShouldBeNil)
+			So(n1.IsDeleted(), ShouldBeTrue)
+
+			n, _ := conn.Session.DB("mogotest").C("notes").Count()
+			So(n, ShouldEqual, 2)
+			f := NewDoc(Note{}).(*Note)
+			n, _ = Find(f, nil).MgoQ.Count()
+			So(n, ShouldEqual, 0)
+
+			// already deleted
+			n3 := NewDoc(Note{}).(*Note)
+			n3.SetID(n2.ID)
+			res, err := n1.GetColl().Bulk().Remove(n3).Run()
+			So(err, ShouldEqual, ErrBulkFailed)
+			So(res.Items[0], ShouldResemble, BulkItem{ID: n2.ID, Err: mgo.ErrNotFound})
+			So(n3.IsDeleted(), ShouldBeFalse)
+		})
+
+		Convey("should report the version conflicts", func() {
+			conn.Session.DB("mogotest").C("versioned-test").RemoveAll(nil)
+			v1 := NewDoc(versionedDocument{Name: "a"}).(*versionedDocument)
+			v2 := NewDoc(versionedDocument{Name: "b"}).(*versionedDocument)
+			So(Save(v1), ShouldBeNil)
+			So(Save(v2), ShouldBeNil)
+
+			stale := NewDoc(versionedDocument{}).(*versionedDocument)
+			So(FindID(stale, v1.ID).One(stale), ShouldBeNil)
+			v1.Name = "a2"
+			So(Save(v1), ShouldBeNil)
+
+			stale.Name = "a3"
+			v2.Name = "b2"
+			res, err := v1.GetColl().Bulk().Unordered().Upsert(stale, v2).Run()
+			So(err, ShouldEqual, ErrBulkFailed)
+			So(res.Items, ShouldResemble, []BulkItem{{ID: v1.ID, Err: ErrVersionConflict}, {ID: v2.ID}})
+			So(res.Errors(), ShouldResemble, map[bson.ObjectId]error{v1.ID: ErrVersionConflict})
+			So(res.Matched, ShouldEqual, 1)
+			So(stale.Version, ShouldEqual, 1)
+			So(v2.Version, ShouldEqual, 2)
+
+			f := NewDoc(versionedDocument{}).(*versionedDocument)
+			So(FindID(f, v1.ID).One(f), ShouldBeNil)
+			So(f.Name, ShouldEqual, "a2")
+		})
+
+		Convey("should run the writes through the plugins", func() {
+			ops := []HookOp{}
+			ModelRegistry.Use("Ticket", func(next Handler) Handler {
+				return func(op *Operation) error {
+					err := next(op)
+					ops = append(ops, op.Hook.Op)
+					return err
+				}
+			})
+			defer func() { ModelRegistry.Model("Ticket").Plugins = nil }()
+
+			b := NewDoc(Ticket{Title: "b"}).(*Ticket)
+			_, err := a.GetColl().Bulk().Insert(b).Remove(a).Run()
+			So(err, ShouldBeNil)
+			So(ops, ShouldResemble, []HookOp{OpSave, OpRemove})
+			So(b.saved, ShouldEqual, 1)
+			So(a.removed, ShouldEqual, 1)
+		})
+	})
+}
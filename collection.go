@@ -1,6 +1,7 @@
 package mogo
 
 import (
+	"encoding/json"
 	"strings"
 	"time"
 
@@ -52,7 +53,62 @@ type ValidateHook interface {
 	Validate() []error
 }
 
-// ValidationError ...
+// HookOp is the kind of operation running a hook
+type HookOp string
+
+// Operations passed to the context-aware hooks
+const (
+	OpSave   HookOp = "save"
+	OpRemove HookOp = "remove"
+	OpFind   HookOp = "find"
+	OpUpdate HookOp = "update"
+)
+
+// HookContext is passed to the context-aware hooks
+type HookContext struct {
+	// Context is the Collection (Connection) Context
+	Context *Context
+	// Collection is the mgo collection bound to the session running the operation
+	Collection *mgo.Collection
+	// Op is the running operation
+	Op HookOp
+	// Tx is the running transaction, nil outside of WithTransaction
+	Tx *Tx
+}
+
+// BeforeSaveContextHook is the context-aware version of BeforeSaveHook
+type BeforeSaveContextHook interface {
+	BeforeSaveWithContext(*HookContext) error
+}
+
+// AfterSaveContextHook is the context-aware version of AfterSaveHook
+type AfterSaveContextHook interface {
+	AfterSaveWithContext(*HookContext) error
+}
+
+// BeforeDeleteContextHook is the context-aware version of BeforeDeleteHook
+type BeforeDeleteContextHook interface {
+	BeforeDeleteWithContext(*HookContext) error
+}
+
+// AfterDeleteContextHook is the context-aware version of AfterDeleteHook
+type AfterDeleteContextHook interface {
+	AfterDeleteWithContext(*HookContext) error
+}
+
+// AfterFindContextHook is the context-aware version of AfterFindHook
+type AfterFindContextHook interface {
+	AfterFindWithContext(*HookContext) error
+}
+
+// ValidateContextHook is the context-aware version of ValidateHook
+type ValidateContextHook interface {
+	ValidateWithContext(*HookContext) []error
+}
+
+// ValidationError is returned by Save when the document is not valid. Errors
+// holds *FieldError values (see Fields), the errors of the Validate hooks are
+// wrapped in FieldError entries with the custom rule.
 type ValidationError struct {
 	Errors []error
 }
@@ -69,6 +125,18 @@ type TimeModifiedTracker interface {
 	SetModified(time.Time)
 }
 
+// VersionTracker ...
+type VersionTracker interface {
+	GetVersion() int
+	SetVersion(int)
+}
+
+// SoftDeleteTracker ...
+type SoftDeleteTracker interface {
+	GetDeleted() *time.Time
+	SetDeleted(*time.Time)
+}
+
 // NewTracker ...
 type NewTracker interface {
 	SetIsNew(bool)
@@ -84,6 +152,20 @@ func (v *ValidationError) Error() string {
 	return "Validation failed. (" + strings.Join(errs, ", ") + ")"
 }
 
+// Fields returns the errors as FieldError values, the errors of other types
+// are wrapped with the custom rule
+func (v *ValidationError) Fields() []*FieldError {
+	return wrapFieldErrors(v.Errors)
+}
+
+// MarshalJSON renders the error as {"error": "validation failed", "errors": [...]}
+func (v *ValidationError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Error  string        `json:"error"`
+		Errors []*FieldError `json:"errors"`
+	}{"validation failed", v.Fields()})
+}
+
 // C ...
 func (c *Collection) C() *mgo.Collection {
 	return c.Connection.Session.DB(c.Database).C(c.Name)
@@ -97,9 +179,11 @@ func (c *Collection) collectionOnSession(sess *mgo.Session) *mgo.Collection {
 // FindID is a wrapper to the mgo FindId
 func (c *Collection) FindID(id interface{}) *Query {
 	q := &Query{
-		MgoC: c.C(),
-		MgoQ: c.C().FindId(id),
+		MgoC:  c.C(),
+		Query: bson.M{"_id": id},
+		coll:  c,
 	}
+	q.rebuild()
 
 	return q
 }
@@ -111,6 +195,7 @@ func (c *Collection) Find(query interface{}) *Query {
 		MgoC:     c.C(),
 		Populate: false,
 		Query:    nil,
+		coll:     c,
 	}
 
 	if refactor, ok := query.(bson.M); ok {
@@ -123,7 +208,7 @@ func (c *Collection) Find(query interface{}) *Query {
 	}
 
 	q.Query = query
-	q.MgoQ = c.C().Find(query)
+	q.rebuild()
 
 	return q
 }
@@ -146,6 +231,18 @@ func Find(doc Document, query interface{}) *Query {
 	return nil
 }
 
-// Populate (TODO: make this wrapper)
-func Populate(doc Document, query interface{}, ref string) {
+// Populate is convenience method for DocumentModel.Populate. If query is not nil
+// it is chained to the populate query (see Query.Find)
+func Populate(doc Document, query interface{}, ref string) *Query {
+	if p, ok := doc.(interface {
+		Populate(string) *Query
+	}); ok {
+		q := p.Populate(ref)
+		if q != nil && query != nil {
+			return q.Find(query)
+		}
+		return q
+	}
+
+	return nil
 }
@@ -0,0 +1,159 @@
+package mogo
+
+import (
+	"fmt"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Criteria is a query filter builder using the struct field names of the model
+// instead of the bson ones. Field names are validated and converted to bson names
+// (see GetBsonPath) when the filter is built for a registered model, i.e.:
+//
+//	q, err := mogo.Where("FirstName").Eq("x").And("Age").Gt(3).Build(Person{})
+//	if err == nil {
+//		iter := person.GetColl().Find(q).Iter()
+//	}
+type Criteria struct {
+	field string
+	conds []condition
+	ors   [][]*Criteria
+}
+
+type condition struct {
+	field string
+	op    string
+	value interface{}
+}
+
+// Where starts a new Criteria on the passed field. Nested fields
+// can be referenced using the dot notation (i.e. HomeAddress.Street)
+func Where(field string) *Criteria {
+	return &Criteria{field: field}
+}
+
+// And switches the Criteria to the passed field, next conditions
+// will be applied to it
+func (c *Criteria) And(field string) *Criteria {
+	c.field = field
+	return c
+}
+
+// Eq adds the $eq condition to the current field
+func (c *Criteria) Eq(value interface{}) *Criteria {
+	return c.add("$eq", value)
+}
+
+// Ne adds the $ne condition to the current field
+func (c *Criteria) Ne(value interface{}) *Criteria {
+	return c.add("$ne", value)
+}
+
+// Gt adds the $gt condition to the current field
+func (c *Criteria) Gt(value interface{}) *Criteria {
+	return c.add("$gt", value)
+}
+
+// Gte adds the $gte condition to the current field
+func (c *Criteria) Gte(value interface{}) *Criteria {
+	return c.add("$gte", value)
+}
+
+// Lt adds the $lt condition to the current field
+func (c *Criteria) Lt(value interface{}) *Criteria {
+	return c.add("$lt", value)
+}
+
+// Lte adds the $lte condition to the current field
+func (c *Criteria) Lte(value interface{}) *Criteria {
+	return c.add("$lte", value)
+}
+
+// In adds the $in condition to the current field
+func (c *Criteria) In(values ...interface{}) *Criteria {
+	return c.add("$in", values)
+}
+
+// Nin adds the $nin condition to the current field
+func (c *Criteria) Nin(values ...interface{}) *Criteria {
+	return c.add("$nin", values)
+}
+
+// Exists adds the $exists condition to the current field
+func (c *Criteria) Exists(exists bool) *Criteria {
+	return c.add("$exists", exists)
+}
+
+// Regex adds the $regex condition to the current field
+func (c *Criteria) Regex(pattern string, options string) *Criteria {
+	return c.add("$regex", bson.RegEx{Pattern: pattern, Options: options})
+}
+
+// Or adds an $or condition matching any of the passed Criteria
+func (c *Criteria) Or(alternatives ...*Criteria) *Criteria {
+	c.ors = append(c.ors, alternatives)
+	return c
+}
+
+func (c *Criteria) add(op string, value interface{}) *Criteria {
+	c.conds = append(c.conds, condition{field: c.field, op: op, value: value})
+	return c
+}
+
+// Build returns the bson.M filter for the passed model, it can be used directly
+// with the Find methods. An error is returned if the model is not registered or
+// if a field does not exist in the model.
+func (c *Criteria) Build(model interface{}) (bson.M, error) {
+	n, ri, ok := registryOf(model).Exists(model)
+	if !ok {
+		return nil, fmt.Errorf("model %T is not registered", model)
+	}
+
+	q, err := c.build(ri)
+	if err != nil {
+		return nil, fmt.Errorf("%s (model: %s)", err.Error(), n)
+	}
+
+	return q, nil
+}
+
+func (c *Criteria) build(ri *ModelInternals) (bson.M, error) {
+	q := bson.M{}
+
+	for _, cond := range c.conds {
+		p, err := GetBsonPath(ri.Type, cond.field)
+		if err != nil {
+			return nil, err
+		}
+
+		ops, ok := q[p].(bson.M)
+		if !ok {
+			ops = bson.M{}
+			q[p] = ops
+		}
+		ops[cond.op] = cond.value
+	}
+
+	ors := make([]interface{}, 0, len(c.ors))
+	for _, alternatives := range c.ors {
+		or := make([]bson.M, 0, len(alternatives))
+		for _, a := range alternatives {
+			aq, err := a.build(ri)
+			if err != nil {
+				return nil, err
+			}
+			or = append(or, aq)
+		}
+		ors = append(ors, bson.M{"$or": or})
+	}
+
+	switch len(ors) {
+	case 0:
+	case 1:
+		q["$or"] = ors[0].(bson.M)["$or"]
+	default:
+		q["$and"] = ors
+	}
+
+	return q, nil
+}
@@ -0,0 +1,56 @@
+package mogo
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCriteria(t *testing.T) {
+	ModelRegistry.Register(Person{})
+
+	Convey("Criteria", t, func() {
+		Convey("should build a filter using the bson names of the fields", func() {
+			q, err := Where("FirstName").Eq("x").And("HomeAddress.City").In("Rome", "Milan").Build(Person{})
+			So(err, ShouldBeNil)
+			So(q, ShouldResemble, bson.M{
+				"firstname":        bson.M{"$eq": "x"},
+				"homeaddress.city": bson.M{"$in": []interface{}{"Rome", "Milan"}},
+			})
+		})
+
+		Convey("should merge conditions on the same field and resolve inlined fields", func() {
+			id := bson.NewObjectId()
+			q, err := Where("LastName").Gt("a").Lt("m").And("ID").Ne(id).Build(&Person{})
+			So(err, ShouldBeNil)
+			So(q, ShouldResemble, bson.M{
+				"lastname": bson.M{"$gt": "a", "$lt": "m"},
+				"_id":      bson.M{"$ne": id},
+			})
+		})
+
+		Convey("should build $or conditions", func() {
+			q, err := Where("Gender").Eq("f").Or(Where("FirstName").Eq("x"), Where("LastName").Eq("y")).Build(Person{})
+			So(err, ShouldBeNil)
+			So(q, ShouldResemble, bson.M{
+				"gender": bson.M{"$eq": "f"},
+				"$or": []bson.M{
+					{"firstname": bson.M{"$eq": "x"}},
+					{"lastname": bson.M{"$eq": "y"}},
+				},
+			})
+		})
+
+		Convey("should return an error for unknown fields or models", func() {
+			_, err := Where("FirstNam").Eq("x").Build(Person{})
+			So(err, ShouldNotBeNil)
+			_, err = Where("HomeAddress.Town").Eq("x").Build(Person{})
+			So(err, ShouldNotBeNil)
+			_, err = Where("Gender").Eq("x").Or(Where("Foo").Eq(1)).Build(Person{})
+			So(err, ShouldNotBeNil)
+			_, err = Where("Name").Eq("x").Build(BadDocument{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
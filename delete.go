@@ -8,33 +8,11 @@ import (
 // Remove removes the passed document from database, executing
 // before and after delete hooks
 func (c *Collection) Remove(doc Document) error {
-	var err error
 	// Create a new session per mgo's suggestion to avoid blocking
 	sess := c.Connection.Session.Clone()
 	defer sess.Close()
-	col := c.collectionOnSession(sess)
-
-	if hook, ok := doc.(BeforeDeleteHook); ok {
-		err := hook.BeforeDelete()
-		if err != nil {
-			return err
-		}
-	}
-
-	err = col.RemoveId(doc.GetID())
-
-	if err != nil {
-		return err
-	}
 
-	if hook, ok := doc.(AfterDeleteHook); ok {
-		err = hook.AfterDelete()
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return c.remove(sess, doc, false)
 }
 
 // RemoveAll removes all documents passed in slice executing,
@@ -43,34 +21,15 @@ func (c *Collection) Remove(doc Document) error {
 func (c *Collection) RemoveAll(docs []Document) map[bson.ObjectId]error {
 	var err error
 	var errs = make(map[bson.ObjectId]error, 0)
-	var col *mgo.Collection
 
 	// Create a new session per mgo's suggestion to avoid blocking
 	sess := c.Connection.Session.Clone()
 	defer sess.Close()
 
 	for _, d := range docs {
-		col = d.GetColl().collectionOnSession(sess)
-
-		if hook, ok := d.(BeforeDeleteHook); ok {
-			err = hook.BeforeDelete()
-			if err != nil {
-				errs[d.GetID()] = err
-				continue
-			}
-		}
-		err = col.Remove(d.BsonID)
+		err = d.GetColl().remove(sess, d, false)
 		if err != nil {
 			errs[d.GetID()] = err
-			continue
-		}
-
-		if hook, ok := d.(AfterDeleteHook); ok {
-			err = hook.AfterDelete()
-			if err != nil {
-				errs[d.GetID()] = err
-				continue
-			}
 		}
 	}
 
@@ -81,6 +40,34 @@ func (c *Collection) RemoveAll(docs []Document) map[bson.ObjectId]error {
 	return errs
 }
 
+// remove removes the document through the plugin pipeline, applying the
+// ondelete policies of the references. Documents of soft delete models are
+// only marked as deleted, unless force is true.
+func (c *Collection) remove(sess *mgo.Session, doc Document, force bool) error {
+	col := c.collectionOnSession(sess)
+
+	op := c.operation(col, OpRemove, doc)
+	op.Force = force
+	return runPipeline(c.Connection, op, func(op *Operation) error {
+		soft := !op.Force && isSoftDelete(op.Doc)
+		err := checkRestrict(sess, op.Doc, !soft)
+		if err != nil {
+			return err
+		}
+
+		if soft {
+			return softRemove(col, op.Doc)
+		}
+
+		err = col.RemoveId(op.Doc.GetID())
+		if err != nil {
+			return err
+		}
+
+		return applyOnDelete(sess, op.Doc)
+	})
+}
+
 // RemoveBySelector is a wrapper aorund mgo.Remove method.
 // This is faster the Collection.Remove method but it doesn't run
 // before / after delete hooks
@@ -91,7 +78,11 @@ func (c *Collection) RemoveBySelector(selector interface{}) error {
 	defer sess.Close()
 	col := c.collectionOnSession(sess)
 
-	err = col.Remove(selector)
+	op := c.operation(col, OpRemove, nil)
+	op.Selector = selector
+	err = runPipeline(c.Connection, op, func(op *Operation) error {
+		return col.Remove(op.Selector)
+	})
 
 	if err != nil {
 		return err
@@ -112,7 +103,6 @@ func (c *Collection) RemoveBySelector(selector interface{}) error {
 //
 func (c *Collection) RemoveAllBySelector(selectors map[Model]interface{}) map[string]*ChangeInfoWithError {
 	var err error
-	var info *mgo.ChangeInfo
 	var errs = make(map[string]*ChangeInfoWithError)
 
 	// Create a new session per mgo's suggestion to avoid blocking
@@ -120,12 +110,20 @@ func (c *Collection) RemoveAllBySelector(selectors map[Model]interface{}) map[st
 	defer sess.Close()
 
 	for m, s := range selectors {
-		col := m.GetColl().collectionOnSession(sess)
-		info, err = col.RemoveAll(s)
+		mc := m.GetColl()
+		col := mc.collectionOnSession(sess)
+
+		op := mc.operation(col, OpRemove, nil)
+		op.Model, _ = m.GetMe()
+		op.Selector = s
+		err = runPipeline(c.Connection, op, func(op *Operation) error {
+			info, err := col.RemoveAll(op.Selector)
+			op.Info = info
+			return err
+		})
 
 		if err != nil {
-			iname, _ := m.GetMe()
-			errs[iname] = &ChangeInfoWithError{Info: info, Err: err}
+			errs[op.Model] = &ChangeInfoWithError{Info: op.Info, Err: err}
 		}
 	}
 
@@ -2,11 +2,11 @@ package mogo
 
 import (
 	"fmt"
-
-	// "github.com/go-mogo/mgo/bson"
 	"reflect"
+	"sort"
 	"strings"
 
+	"github.com/globalsign/mgo/bson"
 	dotaccess "github.com/go-bongo/go-dotaccess"
 )
 
@@ -21,6 +21,14 @@ type Trackable interface {
 	GetDiffTracker() *DiffTracker
 }
 
+// DiffTrackerSetter is implemented by the Trackable documents that can replace
+// their diff tracker. A document copied by value shares the tracker of the
+// original one, the copies made by mogo (i.e. the elements of a []T result)
+// get their own tracker through SetDiffTracker.
+type DiffTrackerSetter interface {
+	SetDiffTracker(*DiffTracker)
+}
+
 // NewDiffTracker ...
 func NewDiffTracker(doc interface{}) *DiffTracker {
 	c := &DiffTracker{
@@ -31,6 +39,15 @@ func NewDiffTracker(doc interface{}) *DiffTracker {
 	return c
 }
 
+// Copy returns a new tracker of doc, with the same original state of d
+func (d *DiffTracker) Copy(doc interface{}) *DiffTracker {
+	// the original snapshot is never changed in place, it can be shared
+	return &DiffTracker{
+		current:  doc,
+		original: d.original,
+	}
+}
+
 // DiffTrackingSession ...
 type DiffTrackingSession struct {
 	ChangedFields []string
@@ -51,8 +68,9 @@ func (d *DiffTracker) NewSession(useBsonTags bool) (*DiffTrackingSession, error)
 
 // Reset ...
 func (d *DiffTracker) Reset() {
-	// Store a copy of current
-	d.original = reflect.Indirect(reflect.ValueOf(d.current)).Interface()
+	// Store a deep copy of current, so that the in place changes of slices,
+	// maps and pointed structs are detected
+	d.original = snapshot(d.current)
 }
 
 // Modified ...
@@ -94,7 +112,73 @@ func (d *DiffTracker) GetOriginalValue(field string) (interface{}, error) {
 
 // SetOriginal ...
 func (d *DiffTracker) SetOriginal(orig interface{}) {
-	d.original = reflect.Indirect(reflect.ValueOf(orig)).Interface()
+	d.original = snapshot(orig)
+}
+
+// snapshot returns a deep copy of the value pointed by i
+func snapshot(i interface{}) interface{} {
+	return deepCopy(reflect.Indirect(reflect.ValueOf(i)), map[uintptr]reflect.Value{}).Interface()
+}
+
+// deepCopy returns a copy of v sharing no slice, map or pointer with it. The
+// unexported struct fields are copied as they are, since they are not tracked.
+// seen holds the copies of the pointers already visited, to preserve cycles.
+func deepCopy(v reflect.Value, seen map[uintptr]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		if c, ok := seen[v.Pointer()]; ok {
+			return c
+		}
+		c := reflect.New(v.Type().Elem())
+		seen[v.Pointer()] = c
+		c.Elem().Set(deepCopy(v.Elem(), seen))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem(), seen))
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), seen))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), seen))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			c.SetMapIndex(k, deepCopy(v.MapIndex(k), seen))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopy(v.Field(i), seen))
+			}
+		}
+		return c
+	}
+
+	return v
 }
 
 // Clear ...
@@ -118,7 +202,117 @@ func (d *DiffTracker) Compare(useBson bool) (bool, []string, error) {
 	return true, []string{}, nil
 }
 
-func getFields(t reflect.Type) []string {
+// BsonUpdate builds an mgo update document with the $set and $unset operators
+// for the bson paths changed since the last Reset. It returns nil if the tracker
+// has no original state to compare with (i.e. the document must be fully written).
+func (d *DiffTracker) BsonUpdate() (bson.M, error) {
+	isNew, diffs, err := d.Compare(true)
+	if err != nil || isNew {
+		return nil, err
+	}
+
+	original, err := toBsonM(d.original)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := toBsonM(d.current)
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{}
+	unset := bson.M{}
+	paths := []string{}
+
+	sort.Strings(diffs)
+	for _, diff := range diffs {
+		p := settablePath(original, current, diff)
+		if pathCovered(paths, p) {
+			continue
+		}
+		paths = append(paths, p)
+
+		if v, ok := lookupPath(current, p); ok {
+			set[p] = v
+		} else {
+			unset[p] = ""
+		}
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	return update, nil
+}
+
+// toBsonM converts the passed struct to its bson.M representation
+func toBsonM(i interface{}) (bson.M, error) {
+	var m bson.M
+
+	raw, err := bson.Marshal(i)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bson.Unmarshal(raw, &m)
+	return m, err
+}
+
+// lookupPath returns the value stored in m at the dotted path p
+func lookupPath(m bson.M, p string) (interface{}, bool) {
+	var v interface{} = m
+
+	for _, k := range strings.Split(p, ".") {
+		sub, ok := v.(bson.M)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = sub[k]; !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+// settablePath shortens the path p to the first parent that is not an embedded
+// document in both original and current, because mongo can not $set a field
+// inside a null (or missing) parent.
+func settablePath(original bson.M, current bson.M, p string) string {
+	keys := strings.Split(p, ".")
+
+	for i := 1; i < len(keys); i++ {
+		parent := strings.Join(keys[:i], ".")
+		o, _ := lookupPath(original, parent)
+		c, _ := lookupPath(current, parent)
+		if _, ok := o.(bson.M); !ok {
+			return parent
+		}
+		if _, ok := c.(bson.M); !ok {
+			return parent
+		}
+	}
+
+	return p
+}
+
+func pathCovered(paths []string, p string) bool {
+	for _, q := range paths {
+		if p == q || strings.HasPrefix(p, q+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getFields(t reflect.Type, useBson bool) []string {
 	fields := []string{}
 
 	if t.Kind() == reflect.Ptr {
@@ -127,7 +321,16 @@ func getFields(t reflect.Type) []string {
 
 	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
-		fields = append(fields, field.Name)
+		// Skip if not exported
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+
+		if useBson {
+			fields = append(fields, GetBsonName(field))
+		} else {
+			fields = append(fields, field.Name)
+		}
 	}
 
 	return fields
@@ -191,6 +394,10 @@ func GetChangedFields(struct1 interface{}, struct2 interface{}, useBson bool) ([
 		var fieldName string
 		if useBson {
 			fieldName = GetBsonName(field)
+			// Not stored in database
+			if fieldName == "-" {
+				continue
+			}
 		} else {
 			fieldName = field.Name
 		}
@@ -214,7 +421,7 @@ func GetChangedFields(struct1 interface{}, struct2 interface{}, useBson bool) ([
 			if isNilOrInvalid(field1) && isNilOrInvalid(field2) {
 				continue
 			} else if isNilOrInvalid(field1) || isNilOrInvalid(field2) {
-				childDiffs = getFields(childType)
+				childDiffs = getFields(childType, useBson)
 
 			} else {
 				if _, ok := field1.Interface().(Stringer); ok {
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/globalsign/mgo/bson"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -26,6 +27,26 @@ func (f *FooChangeTest) GetDiffTracker() *DiffTracker {
 	return f.diffTracker
 }
 
+func (f *FooChangeTest) SetDiffTracker(d *DiffTracker) {
+	f.diffTracker = d
+}
+
+type DeepChangeTest struct {
+	DocumentModel `bson:",inline" coll:"change-test"`
+	Tags          []string
+	Attrs         map[string]int
+	Foo           *FooBarChangeTest
+	diffTracker   *DiffTracker
+}
+
+func (f *DeepChangeTest) GetDiffTracker() *DiffTracker {
+	if f.diffTracker == nil {
+		f.diffTracker = NewDiffTracker(f)
+	}
+
+	return f.diffTracker
+}
+
 type FooBarChangeTest struct {
 	FooVal *FooChangeTest
 	BarVal string
@@ -109,6 +130,88 @@ func TestDiffTracker(t *testing.T) {
 			sess, _ = foo1.GetDiffTracker().NewSession(false)
 			So(sess.Modified("StringVal"), ShouldEqual, true)
 		})
+
+		Convey("should give its own tracker to a copied document", func() {
+			foo1 := &FooChangeTest{
+				StringVal: "foo",
+				IntVal:    1,
+			}
+			foo1.GetDiffTracker().Reset()
+
+			foo2 := *foo1
+			restoreMe(reflect.ValueOf(&foo2))
+			So(foo2.GetDiffTracker(), ShouldNotEqual, foo1.GetDiffTracker())
+
+			foo2.StringVal = "bar"
+			So(foo2.GetDiffTracker().Modified("StringVal"), ShouldBeTrue)
+			So(foo1.GetDiffTracker().Modified("StringVal"), ShouldBeFalse)
+
+			foo1.IntVal = 2
+			So(foo1.GetDiffTracker().Modified("IntVal"), ShouldBeTrue)
+			So(foo2.GetDiffTracker().Modified("IntVal"), ShouldBeFalse)
+		})
+
+		Convey("should build a $set/$unset update with the changed bson paths", func() {
+			foo1 := &FooChangeTest{
+				StringVal: "foo",
+				IntVal:    1,
+				Arr:       []string{"a"},
+			}
+
+			foo1.GetDiffTracker().Reset()
+			update, err := foo1.GetDiffTracker().BsonUpdate()
+			So(err, ShouldBeNil)
+			So(update, ShouldResemble, bson.M{})
+
+			foo1.StringVal = "bar"
+			foo1.Arr = nil
+			update, err = foo1.GetDiffTracker().BsonUpdate()
+			So(err, ShouldBeNil)
+			So(update["$set"], ShouldResemble, bson.M{"stringval": "bar", "arr": []interface{}{}})
+			So(update["$unset"], ShouldBeNil)
+		})
+
+		Convey("should detect in place changes of slices, maps and pointed structs", func() {
+			deep := &DeepChangeTest{
+				Tags:  []string{"a", "b"},
+				Attrs: map[string]int{"a": 1},
+				Foo:   &FooBarChangeTest{BarVal: "bar"},
+			}
+			tracker := deep.GetDiffTracker()
+			tracker.Reset()
+
+			_, diffs := tracker.GetModified(true)
+			So(diffs, ShouldBeEmpty)
+
+			deep.Tags[1] = "c"
+			So(tracker.Modified("Tags"), ShouldBeTrue)
+
+			deep.Attrs["a"] = 2
+			So(tracker.Modified("Attrs"), ShouldBeTrue)
+
+			deep.Foo.BarVal = "BAR"
+			So(tracker.Modified("Foo.BarVal"), ShouldBeTrue)
+
+			update, err := tracker.BsonUpdate()
+			So(err, ShouldBeNil)
+			So(update["$set"], ShouldResemble, bson.M{
+				"tags":       []interface{}{"a", "c"},
+				"attrs":      bson.M{"a": 2},
+				"foo.barval": "BAR",
+			})
+
+			tracker.Reset()
+			_, diffs = tracker.GetModified(true)
+			So(diffs, ShouldBeEmpty)
+		})
+
+		Convey("should return a nil update if the tracker was never reset", func() {
+			foo1 := &FooChangeTest{}
+
+			update, err := foo1.GetDiffTracker().BsonUpdate()
+			So(err, ShouldBeNil)
+			So(update, ShouldBeNil)
+		})
 	})
 
 }
@@ -1,7 +1,6 @@
 package mogo
 
 import (
-	"errors"
 	"reflect"
 	"time"
 
@@ -70,6 +69,8 @@ type DocumentModel struct {
 	ID       bson.ObjectId `bson:"_id,omitempty" json:"_id"`
 	Created  time.Time     `bson:"_created" json:"_created"`
 	Modified time.Time     `bson:"_modified" json:"_modified"`
+	Version  int           `bson:"_version,omitempty" json:"_version,omitempty"`
+	Deleted  *time.Time    `bson:"_deleted,omitempty" json:"_deleted,omitempty"`
 
 	// Model index in registry
 	iname string `bson:"-"`
@@ -81,6 +82,12 @@ type DocumentModel struct {
 
 	// mgo.ChangeInfo
 	cinfo *mgo.ChangeInfo `bson:"-"`
+
+	// Connection, database and collection the document is bound to, the
+	// defaults are DBConn, its database and the model collection
+	conn *Connection `bson:"-"`
+	db   string      `bson:"-"`
+	coll string      `bson:"-"`
 }
 
 // RefField is a reference field to another model. The receiver will return the real object.
@@ -170,6 +177,41 @@ func (d *DocumentModel) SetModified(t time.Time) {
 	d.Modified = t
 }
 
+// SetVersion sets the document version (used only by versioned models)
+func (d *DocumentModel) SetVersion(v int) {
+	d.Version = v
+}
+
+// GetVersion gets the document version
+func (d *DocumentModel) GetVersion() int {
+	return d.Version
+}
+
+// SetDeleted sets the soft delete time (used only by soft delete models)
+func (d *DocumentModel) SetDeleted(t *time.Time) {
+	d.Deleted = t
+}
+
+// GetDeleted gets the soft delete time, nil if the document is not deleted
+func (d *DocumentModel) GetDeleted() *time.Time {
+	return d.Deleted
+}
+
+// IsDeleted returns true if the document is soft deleted
+func (d *DocumentModel) IsDeleted() bool {
+	return d.Deleted != nil
+}
+
+// Restore is the wrapper method to Collection.Restore
+func (d *DocumentModel) Restore() error {
+	return d.GetColl().Restore(d.me.(Document))
+}
+
+// ForceRemove is the wrapper method to Collection.ForceRemove
+func (d *DocumentModel) ForceRemove() error {
+	return d.GetColl().ForceRemove(d.me.(Document))
+}
+
 // The Model interface implementation
 
 // GetModified gets the modified date
@@ -179,31 +221,50 @@ func (d *DocumentModel) GetModified() time.Time {
 
 // GetCollName implementation for the Model interface
 func (d *DocumentModel) GetCollName() string {
-	_, ri, ok := ModelRegistry.Exists(d)
+	_, ri, ok := d.models().ExistsByName(d.iname)
 	if !ok {
 		panic("the document model is not registered")
 	}
 
+	if d.coll != "" {
+		return d.coll
+	}
+
 	return ri.Collection
 }
 
-// SetCollName implementation for Model interface (why may you need to change collection name at runtime ?)
+// SetCollName binds the document to the named collection instead of the
+// model one (i.e. to store the documents of a model in archive collections).
+// An empty name restores the model collection.
 func (d *DocumentModel) SetCollName(name string) {
+	d.coll = name
 }
 
-// GetColl implementation for Model interface
-func (d *DocumentModel) GetColl() *Collection {
-	_, ri, ok := ModelRegistry.ExistsByName(d.iname)
-	if !ok {
-		panic("the document model is not registered")
+// GetDatabase returns the database the document is bound to, the database
+// of the connection by default
+func (d *DocumentModel) GetDatabase() string {
+	if d.db != "" {
+		return d.db
 	}
 
-	return DBConn.Collection(ri.Collection)
+	return d.GetConn().Config.Database
+}
+
+// SetDatabase binds the document to the named database of its connection.
+// An empty name restores the connection database.
+func (d *DocumentModel) SetDatabase(name string) {
+	d.db = name
+}
+
+// GetColl implementation for Model interface. The collection follows the
+// connection, database and collection the document is bound to.
+func (d *DocumentModel) GetColl() *Collection {
+	return d.GetConn().CollectionFromDatabase(d.GetCollName(), d.GetDatabase())
 }
 
 // GetParsedIndex returns the index stored with the passed field name
 func (d *DocumentModel) GetParsedIndex(name string) []ParsedIndex {
-	_, ri, ok := ModelRegistry.ExistsByName(d.iname)
+	_, ri, ok := d.models().ExistsByName(d.iname)
 	if !ok {
 		panic("the document model is not registered")
 	}
@@ -213,7 +274,7 @@ func (d *DocumentModel) GetParsedIndex(name string) []ParsedIndex {
 
 // GetAllParsedIndex returns all stored parsed indexes
 func (d *DocumentModel) GetAllParsedIndex() map[string][]ParsedIndex {
-	_, ri, ok := ModelRegistry.ExistsByName(d.iname)
+	_, ri, ok := d.models().ExistsByName(d.iname)
 	if !ok {
 		panic("the document model is not registered")
 	}
@@ -257,7 +318,7 @@ func (d *DocumentModel) GetAllIndex() []*mgo.Index {
 
 // GetRefIndex returns the RefIndex struct for the given field
 func (d *DocumentModel) GetRefIndex(name string) RefIndex {
-	_, ri, ok := ModelRegistry.ExistsByName(d.iname)
+	_, ri, ok := d.models().ExistsByName(d.iname)
 	if !ok {
 		panic("the document model is not registered")
 	}
@@ -267,7 +328,7 @@ func (d *DocumentModel) GetRefIndex(name string) RefIndex {
 
 // Ref same as GetRefIndex
 func (d *DocumentModel) Ref(name string) RefIndex {
-	_, ri, ok := ModelRegistry.ExistsByName(d.iname)
+	_, ri, ok := d.models().ExistsByName(d.iname)
 	if !ok {
 		panic("the document model is not registered")
 	}
@@ -275,19 +336,85 @@ func (d *DocumentModel) Ref(name string) RefIndex {
 	return ri.Refs[name]
 }
 
-// SetConn ...
+// SetConn binds the document to the connection c, the document model must be
+// registered in the registry of c (see Connection.Registry). A nil connection
+// restores the default DBConn.
 func (d *DocumentModel) SetConn(c *Connection) {
+	d.conn = c
 }
 
-// GetConn ...
+// GetConn returns the connection the document is bound to, DBConn by default
 func (d *DocumentModel) GetConn() *Connection {
-	if ModelRegistry.Index(d.iname) == -1 {
+	if d.models().Index(d.iname) == -1 {
 		panic("the document model is not registered")
 	}
 
+	return d.connection()
+}
+
+// connection returns the connection the document is bound to
+func (d *DocumentModel) connection() *Connection {
+	if d.conn != nil {
+		return d.conn
+	}
+
 	return DBConn
 }
 
+// models returns the registry of the document model, that is the registry
+// of the connection the document is bound to
+func (d *DocumentModel) models() ModelReg {
+	return d.connection().Models()
+}
+
+// binding returns the connection, database and collection the document is
+// bound to (empty values for the defaults)
+func (d *DocumentModel) binding() docBinding {
+	return docBinding{d.conn, d.db, d.coll}
+}
+
+// bind binds the document to the passed connection, database and collection
+func (d *DocumentModel) bind(b docBinding) {
+	d.conn, d.db, d.coll = b.conn, b.db, b.coll
+}
+
+// docBinding is the connection, database and collection a document is bound to
+type docBinding struct {
+	conn *Connection
+	db   string
+	coll string
+}
+
+// binder is implemented by the documents embedding DocumentModel
+type binder interface {
+	binding() docBinding
+	bind(docBinding)
+}
+
+// bindingOf returns the binding of the document d
+func bindingOf(d interface{}) docBinding {
+	if b, ok := d.(binder); ok {
+		return b.binding()
+	}
+
+	return docBinding{}
+}
+
+// apply binds the document d
+func (b docBinding) apply(d interface{}) {
+	if bd, ok := d.(binder); ok {
+		bd.bind(b)
+	}
+}
+
+// bindLike binds doc to the connection and database of src, the collection
+// is the model one
+func bindLike(doc interface{}, src interface{}) {
+	b := bindingOf(src)
+	b.coll = ""
+	b.apply(doc)
+}
+
 // GetMe is used to save the iname, me fields of the document model.
 // This is useful after returning from one of a find method where mgo
 // driver return a freshly create zero filled struct.DocumentModel
@@ -304,22 +431,28 @@ func (d *DocumentModel) SetMe(iname string, me interface{}) {
 
 // Find is the wrapper method to mgo Find
 func (d *DocumentModel) Find(query interface{}) *Query {
+	c := d.GetColl()
 	q := &Query{
-		MgoC:       d.GetColl().C(),
-		MgoQ:       d.GetColl().C().Find(query),
+		MgoC:       c.C(),
+		Query:      query,
 		Pagination: nil,
+		coll:       c,
 	}
+	q.rebuild()
 
 	return q
 }
 
 // FindID is a wrapper to the mgo FindId
 func (d *DocumentModel) FindID(id interface{}) *Query {
+	c := d.GetColl()
 	q := &Query{
-		MgoC:       d.GetColl().C(),
-		MgoQ:       d.GetColl().C().FindId(id),
+		MgoC:       c.C(),
+		Query:      bson.M{"_id": id},
 		Pagination: nil,
+		coll:       c,
 	}
+	q.rebuild()
 
 	return q
 }
@@ -332,7 +465,8 @@ func (d *DocumentModel) FindOne(query interface{}, result interface{}) error {
 // Populate builds a Query to populate the referenced field (see scratch)
 // The returned Query object refers to the target field object not the original one.
 func (d *DocumentModel) Populate(f string) *Query {
-	_, i, _ := ModelRegistry.Exists(d.me)
+	reg := d.models()
+	_, i, _ := reg.Exists(d.me)
 	if i == nil { // model is not registered
 		return nil
 	}
@@ -343,7 +477,8 @@ func (d *DocumentModel) Populate(f string) *Query {
 	}
 
 	iField := reflect.ValueOf(d.me).Elem().Field(r.Idx).Interface()
-	t := ModelRegistry.New(r.Ref).(Document)
+	t := reg.New(r.Ref).(Document)
+	bindLike(t, d)
 	var q = bson.M{"$populate": make([]bson.M, 0)}
 
 	switch i.Refs[f].Kind {
@@ -366,133 +501,55 @@ func (d *DocumentModel) Populate(f string) *Query {
 
 }
 
+// PopulateAll loads the documents referenced by the passed ref fields (see PopulateAll)
+func (d *DocumentModel) PopulateAll(fields ...string) (map[string]*Populated, error) {
+	return PopulateAll(d.me, fields...)
+}
+
 // FindByID is a shortcut for FindID().One()
 func (d *DocumentModel) FindByID(id interface{}, result interface{}) error {
 	return d.FindID(id).One(result)
 }
 
-// Save ...
+// Save is a wrapper to Collection.Save for the document
 func (d *DocumentModel) Save() error {
-	var err error
-	var cinfo *mgo.ChangeInfo
-
-	c := d.GetColl()
-	sess := c.Connection.Session.Clone()
-	defer sess.Close()
-
-	// Per mgo's recommendation, create a clone of the session so there is no blocking
-	col := c.collectionOnSession(sess)
-
-	err = c.PreSave(d.me.(Document))
-	if err != nil {
-		return err
-	}
-
-	// If the model implements the NewTracker interface, we'll use that to determine newness. Otherwise always assume it's new
-	isNew := true
-	if newt, ok := d.me.(NewTracker); ok {
-		isNew = newt.IsNew()
-	}
-
-	// Add created/modified time. Also set on the model itself if it has those fields.
-	now := time.Now()
-	if tt, ok := d.me.(TimeCreatedTracker); ok && isNew {
-		tt.SetCreated(now)
-	}
-
-	if tt, ok := d.me.(TimeModifiedTracker); ok {
-		tt.SetModified(now)
-	}
-
-	// If the model has indexes we create them here...
-	idxs := d.GetAllIndex()
-	for i := range idxs {
-		err = col.EnsureIndex(*idxs[i])
-		if err != nil {
-			return err
-		}
-	}
-
-	id := d.GetID()
-	if !isNew && !id.Valid() {
-		return errors.New("New tracker says this document isn't new but there is no valid Id field")
-	}
-
-	if isNew && !id.Valid() {
-		// Generate an Id
-		id = bson.NewObjectId()
-		d.SetID(id)
-	}
-
-	cinfo, err = col.UpsertId(id, d.me)
-	d.SetCInfo(cinfo)
-
-	if err != nil {
-		return err
-	}
-
-	if hook, ok := d.me.(AfterSaveHook); ok {
-		err = hook.AfterSave()
-		if err != nil {
-			return err
-		}
-	}
-
-	// We saved it, no longer new
-	if newt, ok := d.me.(NewTracker); ok {
-		newt.SetIsNew(false)
-	}
-
-	return nil
+	return d.GetColl().Save(d.me.(Document))
 }
 
 // Remove removes document from database, running
-// before and after delete hooks
+// before and after delete hooks (see Collection.Remove)
 func (d *DocumentModel) Remove() error {
-	var err error
-	// Create a new session per mgo's suggestion to avoid blocking
-	c := d.GetColl()
-	sess := c.Connection.Session.Clone()
-	defer sess.Close()
-	col := c.collectionOnSession(sess)
-
-	if hook, ok := d.me.(BeforeDeleteHook); ok {
-		err := hook.BeforeDelete()
-		if err != nil {
-			return err
-		}
-	}
+	return d.GetColl().Remove(d.me.(Document))
+}
 
-	err = col.RemoveId(d.GetID())
+// NewDoc ...
+func NewDoc(d interface{}) interface{} {
+	return newDoc(DBConn.Models(), d)
+}
 
-	if err != nil {
-		return err
-	}
+// NewDoc creates a new document registered in the connection registry and
+// bound to the connection (see NewDoc)
+func (m *Connection) NewDoc(d interface{}) interface{} {
+	doc := newDoc(m.Models(), d)
+	doc.(Document).SetConn(m)
 
-	if hook, ok := d.me.(AfterDeleteHook); ok {
-		err = hook.AfterDelete()
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return doc
 }
 
-// NewDoc ...
-func NewDoc(d interface{}) interface{} {
+// newDoc creates a new document of the model registered in reg
+func newDoc(reg ModelReg, d interface{}) interface{} {
 	var n string
 	var ri *ModelInternals
 	var ok bool
 
-	n, ri, ok = ModelRegistry.ExistsByName(interfaceName(d))
+	v := ValueOf(d) // the struct value, also for slices and maps of documents
+	n, ri, ok = reg.Exists(v.Interface())
 	if !ok { // Trying to register (?to be removed?)
-		ModelRegistry.Register(d)
-		n, ri, _ = ModelRegistry.Exists(d)
+		reg.Register(v.Interface())
+		n, ri, _ = reg.Exists(v.Interface())
 	}
 	t := ri.Type
-	v := ValueOf(d)
-	i := ModelRegistry.Index(n) // The dm
+	i := ri.Idx // The dm
 
 	r := reflect.New(t)
 	r.Elem().Set(v)
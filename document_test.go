@@ -115,12 +115,12 @@ func TestGetParsedIndex(t *testing.T) {
 		doc := NewDoc(DocumentWithModelAndIdx{}).(*DocumentWithModelAndIdx)
 		pi := doc.GetParsedIndex("Name")
 		So(pi, ShouldResemble, []ParsedIndex{
-			ParsedIndex{[]string{"name"}, []string{"unique", "sparse"}, 0, false}})
+			ParsedIndex{Fields: []string{"name"}, Options: []string{"unique", "sparse"}}})
 		pi = doc.GetParsedIndex("Boh")
 		So(pi, ShouldBeNil)
 		rm := make(map[string][]ParsedIndex, 0)
-		rm["DocumentModel"] = []ParsedIndex{ParsedIndex{[]string{"name", "surname"}, []string{"unique"}, 0, false}}
-		rm["Name"] = []ParsedIndex{ParsedIndex{[]string{"name"}, []string{"unique", "sparse"}, 0, false}}
+		rm["DocumentModel"] = []ParsedIndex{ParsedIndex{Fields: []string{"name", "surname"}, Options: []string{"unique"}}}
+		rm["Name"] = []ParsedIndex{ParsedIndex{Fields: []string{"name"}, Options: []string{"unique", "sparse"}}}
 		rm["Surname"] = nil
 		mi := doc.GetAllParsedIndex()
 		So(mi, ShouldResemble, rm)
@@ -0,0 +1,115 @@
+package mogo
+
+import (
+	"github.com/globalsign/mgo"
+)
+
+// hookContext returns the HookContext for an operation made on col
+func (c *Collection) hookContext(col *mgo.Collection, op HookOp) *HookContext {
+	return &HookContext{
+		Context:    c.Context,
+		Collection: col,
+		Op:         op,
+	}
+}
+
+// The run* functions execute the zero-argument hook first and then the
+// context-aware one, if the document implements them
+
+func runValidate(doc interface{}, hc *HookContext) error {
+	// validate tag rules first
+	errs := validateFields(doc, hc)
+
+	if validator, ok := doc.(ValidateHook); ok {
+		errs = append(errs, wrapFieldErrors(validator.Validate())...)
+	}
+
+	if validator, ok := doc.(ValidateContextHook); ok {
+		errs = append(errs, wrapFieldErrors(validator.ValidateWithContext(hc))...)
+	}
+
+	if len(errs) > 0 {
+		verr := &ValidationError{Errors: make([]error, len(errs))}
+		for i, e := range errs {
+			verr.Errors[i] = e
+		}
+		return verr
+	}
+
+	return nil
+}
+
+func runBeforeSave(doc interface{}, hc *HookContext) error {
+	if hook, ok := doc.(BeforeSaveHook); ok {
+		err := hook.BeforeSave()
+		if err != nil {
+			return err
+		}
+	}
+
+	if hook, ok := doc.(BeforeSaveContextHook); ok {
+		return hook.BeforeSaveWithContext(hc)
+	}
+
+	return nil
+}
+
+func runAfterSave(doc interface{}, hc *HookContext) error {
+	if hook, ok := doc.(AfterSaveHook); ok {
+		err := hook.AfterSave()
+		if err != nil {
+			return err
+		}
+	}
+
+	if hook, ok := doc.(AfterSaveContextHook); ok {
+		return hook.AfterSaveWithContext(hc)
+	}
+
+	return nil
+}
+
+func runBeforeDelete(doc interface{}, hc *HookContext) error {
+	if hook, ok := doc.(BeforeDeleteHook); ok {
+		err := hook.BeforeDelete()
+		if err != nil {
+			return err
+		}
+	}
+
+	if hook, ok := doc.(BeforeDeleteContextHook); ok {
+		return hook.BeforeDeleteWithContext(hc)
+	}
+
+	return nil
+}
+
+func runAfterDelete(doc interface{}, hc *HookContext) error {
+	if hook, ok := doc.(AfterDeleteHook); ok {
+		err := hook.AfterDelete()
+		if err != nil {
+			return err
+		}
+	}
+
+	if hook, ok := doc.(AfterDeleteContextHook); ok {
+		return hook.AfterDeleteWithContext(hc)
+	}
+
+	return nil
+}
+
+func runAfterFind(doc interface{}, hc *HookContext) error {
+	if hook, ok := doc.(AfterFindHook); ok {
+		err := hook.AfterFind()
+		if err != nil {
+			return err
+		}
+	}
+
+	if hook, ok := doc.(AfterFindContextHook); ok {
+		return hook.AfterFindWithContext(hc)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package mogo
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type Audited struct {
+	DocumentModel `bson:",inline" coll:"audited"`
+	Name          string
+	CreatedBy     string
+
+	calls []string
+	ops   []HookOp
+}
+
+func (a *Audited) BeforeSave() error {
+	a.calls = append(a.calls, "BeforeSave")
+	return nil
+}
+
+func (a *Audited) ValidateWithContext(hc *HookContext) []error {
+	if hc.Context.Get("user") == nil {
+		return []error{errors.New("no user")}
+	}
+	return nil
+}
+
+func (a *Audited) BeforeSaveWithContext(hc *HookContext) error {
+	a.calls = append(a.calls, "BeforeSaveWithContext")
+	a.ops = append(a.ops, hc.Op)
+	a.CreatedBy = hc.Context.Get("user").(string)
+	return nil
+}
+
+func (a *Audited) AfterSaveWithContext(hc *HookContext) error {
+	a.ops = append(a.ops, hc.Op)
+	// The collection is bound to the session of the operation
+	n, err := hc.Collection.FindId(a.ID).Count()
+	if n != 1 {
+		return errors.New("not saved")
+	}
+	return err
+}
+
+func (a *Audited) BeforeDeleteWithContext(hc *HookContext) error {
+	a.ops = append(a.ops, hc.Op)
+	return nil
+}
+
+func (a *Audited) AfterFindWithContext(hc *HookContext) error {
+	a.ops = append(a.ops, hc.Op)
+	return nil
+}
+
+func TestContextHooks(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	ModelRegistry.Register(Audited{})
+
+	Convey("Context-aware hooks", t, func() {
+		conn.Context.Delete("user")
+
+		a := NewDoc(Audited{Name: "a"}).(*Audited)
+
+		Convey("should receive the connection context", func() {
+			err := Save(a)
+			So(err, ShouldHaveSameTypeAs, &ValidationError{})
+
+			conn.Context.Set("user", "tester")
+			So(Save(a), ShouldBeNil)
+			So(a.CreatedBy, ShouldEqual, "tester")
+		})
+
+		Convey("should run after the zero-argument hooks", func() {
+			conn.Context.Set("user", "tester")
+			So(Save(a), ShouldBeNil)
+			So(a.calls, ShouldResemble, []string{"BeforeSave", "BeforeSaveWithContext"})
+		})
+
+		Convey("should receive the operation kind", func() {
+			conn.Context.Set("user", "tester")
+			So(Save(a), ShouldBeNil)
+
+			f := NewDoc(Audited{}).(*Audited)
+			So(FindID(f, a.ID).One(f), ShouldBeNil)
+			So(f.ops, ShouldResemble, []HookOp{OpFind})
+
+			So(f.Remove(), ShouldBeNil)
+			So(a.ops, ShouldResemble, []HookOp{OpSave, OpSave})
+			So(f.ops, ShouldResemble, []HookOp{OpFind, OpRemove})
+		})
+	})
+}
@@ -1,23 +1,30 @@
 package mogo
 
 import (
-	"go/scanner"
-	"go/token"
+	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
 )
 
 var optionKeywords = [...]string{"unique", "sparse", "background", "dropdups"}
 
-// ParsedIndex contains a parsed index
+// Options of the idx tag with a value (option=value)
+var valueKeywords = [...]string{"expireafter", "name", "partial", "weights", "collation"}
+
+// Special key types of the idx tag ({$text:field})
+var keyTypes = [...]string{"text", "hashed", "2dsphere", "2d"}
+
+// ParsedIndex contains a parsed index. Fields are the mgo.Index keys (i.e. -name
+// or $text:title), Options are the option keywords, followed by =value for the
+// options with a value (i.e. expireafter=24h).
 type ParsedIndex struct {
 	Fields  []string
 	Options []string
-
-	lastFieldIdx int
-	stickyField  bool
 }
 
 // RefIndex contains the object stored as reference in database
@@ -25,9 +32,12 @@ type RefIndex struct {
 	// The docuemnt model name
 	Model string
 
-	// The referenced object name
+	// The registry name of the referenced model, resolved from Tag
 	Ref string
 
+	// The ref tag, the qualified or the short name of the referenced model
+	Tag string
+
 	// The field index in the parsed struct
 	Idx int
 
@@ -39,79 +49,457 @@ type RefIndex struct {
 
 	// Whenever the reference object exists in the Registry
 	Exists bool
+
+	// The name of the companion field filled by PopulateAll (populate tag)
+	Target string
+
+	// The policy applied when the referenced document is removed (ondelete tag)
+	OnDelete string
+}
+
+// IndexSyntaxError is returned by ParseIndex for a bad idx tag. Pos is the
+// byte offset of the error in Src.
+type IndexSyntaxError struct {
+	Src string
+	Pos int
+	Msg string
+}
+
+func (e *IndexSyntaxError) Error() string {
+	return fmt.Sprintf("Syntax error in parsing index expression at position %d: %s (%s)", e.Pos, e.Msg, e.Src)
 }
 
-// IndexScan ...
-// TODO:
-// 	add optional parameter to pass the name of the field to be used
-// 	in case of empty {} or if filled name is empty
+// IndexScan parses an idx tag as ParseIndex, but it panics on syntax errors.
+//
+// Deprecated: use ParseIndex, which returns the syntax errors.
 func IndexScan(src string) []ParsedIndex {
-	var s scanner.Scanner
+	parsed, err := ParseIndex(src)
+	if err != nil {
+		panic(err)
+	}
+
+	return parsed
+}
+
+// ParseIndex parses an idx tag. The tag is a list of indexes separated by ;
+// where each index is a list of keys in braces followed by the options:
+//
+//	{name,-age},unique;{$text:title,$text:body},weights={title:3}
+//
+// Keys are bson field paths, prefixed by - for descending order or by
+// $text:, $hashed:, $2dsphere: or $2d: for the special indexes. Options
+// are unique, sparse, background, dropdups, expireAfter=<duration>,
+// name=<name>, partial=<filter>, weights=<field weights> and
+// collation=<locale or collation document>. Documents are written as
+// {key:value,...} where keys and strings may be unquoted.
+//
+// Unknown options are ignored, use ParseIndexStrict to reject them.
+func ParseIndex(src string) ([]ParsedIndex, error) {
+	parsed, _, err := parseIndex(src, false)
+	return parsed, err
+}
+
+// ParseIndexStrict is the same as ParseIndex, but unknown options are syntax
+// errors
+func ParseIndexStrict(src string) ([]ParsedIndex, error) {
+	parsed, _, err := parseIndex(src, true)
+	return parsed, err
+}
+
+// parseIndex parses an idx tag. Unknown options are syntax errors in strict
+// mode, otherwise they are skipped and returned as ignored.
+func parseIndex(src string, strict bool) ([]ParsedIndex, []*IndexSyntaxError, error) {
 	var parsed []ParsedIndex
 
-	src = TrimAllSpaces(src)
-	fset := token.NewFileSet()
-	file := fset.AddFile("", fset.Base(), len(src))
-	s.Init(file, []byte(src), nil /* no error handler */, scanner.ScanComments)
+	p := &idxParser{src: src, strict: strict}
+	p.skipSpaces()
+	for !p.end() {
+		idx, err := p.index()
+		if err != nil {
+			return nil, nil, err
+		}
+		parsed = append(parsed, *idx)
+
+		p.skipSpaces()
+		if p.end() {
+			break
+		}
+		if p.peek() != ';' {
+			return nil, nil, p.errorf(p.pos, "expected ; or , found %q", p.peek())
+		}
+		p.pos++
+		p.skipSpaces()
+	}
+
+	return parsed, p.ignored, nil
+}
+
+// idxParser is the parser of the idx tag
+type idxParser struct {
+	src    string
+	pos    int
+	strict bool
+
+	// the unknown options skipped out of strict mode
+	ignored []*IndexSyntaxError
+}
+
+func (p *idxParser) end() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *idxParser) peek() byte {
+	if p.end() {
+		return 0
+	}
+
+	return p.src[p.pos]
+}
+
+func (p *idxParser) skipSpaces() {
+	for !p.end() && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t' || p.src[p.pos] == '\n' || p.src[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+func (p *idxParser) errorf(pos int, format string, args ...interface{}) error {
+	return &IndexSyntaxError{Src: p.src, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// expect skips the spaces and the expected char
+func (p *idxParser) expect(c byte) error {
+	p.skipSpaces()
+	if p.end() {
+		return p.errorf(p.pos, "expected %q, found end of tag", c)
+	}
+	if p.peek() != c {
+		return p.errorf(p.pos, "expected %q, found %q", c, p.peek())
+	}
+	p.pos++
+
+	return nil
+}
+
+// word returns the next sequence of chars allowed in names and values
+func (p *idxParser) word() string {
+	p.skipSpaces()
+	start := p.pos
+	for !p.end() {
+		c := p.peek()
+		if c == '_' || c == '.' || c == '$' || c == '-' || c == '+' ||
+			c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			p.pos++
+			continue
+		}
+		break
+	}
+
+	return p.src[start:p.pos]
+}
+
+// index parses {key,...},option,...
+func (p *idxParser) index() (*ParsedIndex, error) {
+	idx := &ParsedIndex{}
+
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
 
-	// Repeated calls to Scan yield the token sequence found in the input.
-	lb := false
-	p := &ParsedIndex{}
 	for {
-		_, tok, lit := s.Scan()
-
-		switch tok {
-		case token.LBRACE:
-			if lb {
-				goto _panic
-			}
-			lb = true
-		case token.RBRACE:
-			if !lb || len(p.Fields) == 0 {
-				goto _panic
-			}
-			lb = false
-		case token.IDENT:
-			if lb {
-				if p.getStickyField() {
-					p.appendDotField(lit)
-					p.setStickyFieldTo(false)
-					break
-				}
-
-				p.appendField(lit)
-				break
+		p.skipSpaces()
+		if len(idx.Fields) == 0 && p.peek() == '}' {
+			return nil, p.errorf(p.pos, "index without keys")
+		}
+
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		idx.Fields = append(idx.Fields, key)
+
+		p.skipSpaces()
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+		if err = p.expect('}'); err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	for {
+		p.skipSpaces()
+		if p.peek() != ',' {
+			return idx, nil
+		}
+		p.pos++
+
+		opt, err := p.option()
+		if err != nil {
+			return nil, err
+		}
+		if opt != "" {
+			idx.Options = append(idx.Options, opt)
+		}
+	}
+}
+
+// key parses [-]path or $type:path
+func (p *idxParser) key() (string, error) {
+	p.skipSpaces()
+	start := p.pos
+
+	prefix := ""
+	switch p.peek() {
+	case '-':
+		p.pos++
+		prefix = "-"
+	case '$':
+		p.pos++
+		t := p.word()
+		if !stringInSlice(t, keyTypes[:]) {
+			return "", p.errorf(start, "unknown key type %q", "$"+t)
+		}
+		if err := p.expect(':'); err != nil {
+			return "", err
+		}
+		prefix = "$" + t + ":"
+	}
+
+	p.skipSpaces()
+	fpos := p.pos
+	f := p.word()
+	if f == "" || strings.HasPrefix(f, ".") || strings.HasSuffix(f, ".") || strings.Contains(f, "..") ||
+		strings.ContainsAny(f, "$+") || strings.HasPrefix(f, "-") {
+		return "", p.errorf(fpos, "bad field name %q", f)
+	}
+
+	return prefix + f, nil
+}
+
+// option parses a keyword or keyword=value. The returned option has the
+// lower case keyword and the value as written in the tag, it is empty for an
+// unknown option skipped out of strict mode.
+func (p *idxParser) option() (string, error) {
+	p.skipSpaces()
+	start := p.pos
+
+	name := strings.ToLower(p.word())
+	if stringInSlice(name, optionKeywords[:]) {
+		return name, nil
+	}
+
+	if !stringInSlice(name, valueKeywords[:]) {
+		return "", p.unknown(start, name)
+	}
+
+	if err := p.expect('='); err != nil {
+		return "", err
+	}
+
+	p.skipSpaces()
+	vpos := p.pos
+	var err error
+	switch name {
+	case "expireafter":
+		_, err = time.ParseDuration(p.word())
+	case "name":
+		if p.word() == "" {
+			err = fmt.Errorf("empty name")
+		}
+	case "collation":
+		if p.peek() != '{' {
+			if p.word() == "" {
+				err = fmt.Errorf("empty collation")
 			}
-			p.appendOption(lit)
-		case token.PERIOD:
-			if p.getStickyField() {
-				goto _panic
+			break
+		}
+		var v interface{}
+		if v, err = p.value(); err == nil {
+			_, err = toCollation(v)
+		}
+	case "partial", "weights":
+		if p.peek() != '{' {
+			return "", p.errorf(vpos, "expected a document for %s", name)
+		}
+		var v interface{}
+		if v, err = p.value(); err == nil && name == "weights" {
+			_, err = toWeights(v)
+		}
+	}
+
+	if err != nil {
+		if se, ok := err.(*IndexSyntaxError); ok {
+			return "", se
+		}
+		return "", p.errorf(vpos, "bad %s value: %v", name, err)
+	}
+
+	return name + "=" + strings.TrimSpace(p.src[vpos:p.pos]), nil
+}
+
+// unknown returns the error of the unknown option name at pos. Out of strict
+// mode the option and its value, if any, are skipped as the previous versions
+// of the parser did.
+func (p *idxParser) unknown(pos int, name string) error {
+	err := &IndexSyntaxError{Src: p.src, Pos: pos, Msg: fmt.Sprintf("unknown option %q", name)}
+	if p.strict {
+		return err
+	}
+
+	p.skipSpaces()
+	if p.peek() == '=' {
+		p.pos++
+		if _, verr := p.value(); verr != nil {
+			return verr
+		}
+	}
+	p.ignored = append(p.ignored, err)
+
+	return nil
+}
+
+// value parses a document, an array, a quoted string or a word (number,
+// boolean, null or unquoted string)
+func (p *idxParser) value() (interface{}, error) {
+	p.skipSpaces()
+	start := p.pos
+
+	switch c := p.peek(); c {
+	case '{':
+		p.pos++
+		m := bson.M{}
+		for {
+			p.skipSpaces()
+			if len(m) == 0 && p.peek() == '}' {
+				p.pos++
+				return m, nil
+			}
+
+			kpos := p.pos
+			k, err := p.value()
+			if err != nil {
+				return nil, err
 			}
-			p.setStickyFieldTo(true)
-			p.updateLastFieldIdx()
-		case token.COMMA:
-		case token.COLON:
-			if lb {
-				goto _panic
+			ks, ok := k.(string)
+			if !ok || ks == "" {
+				return nil, p.errorf(kpos, "bad document key")
 			}
-		case token.SEMICOLON:
-			if lb {
-				goto _panic
+			if err = p.expect(':'); err != nil {
+				return nil, err
 			}
-			parsed = append(parsed, *p)
-			p = &ParsedIndex{}
-		case token.EOF:
-			if lb {
-				goto _panic
+			if m[ks], err = p.value(); err != nil {
+				return nil, err
+			}
+
+			p.skipSpaces()
+			if p.peek() == ',' {
+				p.pos++
+				continue
 			}
-			return parsed
-		default:
-			goto _panic
+			if err = p.expect('}'); err != nil {
+				return nil, err
+			}
+			return m, nil
+		}
+	case '[':
+		p.pos++
+		a := []interface{}{}
+		for {
+			p.skipSpaces()
+			if len(a) == 0 && p.peek() == ']' {
+				p.pos++
+				return a, nil
+			}
+
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+
+			p.skipSpaces()
+			if p.peek() == ',' {
+				p.pos++
+				continue
+			}
+			if err = p.expect(']'); err != nil {
+				return nil, err
+			}
+			return a, nil
+		}
+	case '"', '\'':
+		p.pos++
+		i := strings.IndexByte(p.src[p.pos:], c)
+		if i < 0 {
+			return nil, p.errorf(start, "unterminated string")
 		}
+		s := p.src[p.pos : p.pos+i]
+		p.pos += i + 1
+		return s, nil
 	}
 
-_panic:
-	panic("Syntax error in parsing index expression")
+	w := p.word()
+	if w == "" {
+		if p.end() {
+			return nil, p.errorf(start, "expected a value, found end of tag")
+		}
+		return nil, p.errorf(start, "expected a value, found %q", p.peek())
+	}
+
+	if i, err := strconv.Atoi(w); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(w, 64); err == nil {
+		return f, nil
+	}
+
+	switch w {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+
+	return w, nil
+}
+
+// toCollation converts the collation document of the tag
+func toCollation(v interface{}) (*mgo.Collation, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &mgo.Collation{}
+	err = bson.Unmarshal(raw, c)
+	if err == nil && c.Locale == "" {
+		err = fmt.Errorf("missing locale")
+	}
+
+	return c, err
+}
+
+// toWeights converts the weights document of the tag
+func toWeights(v interface{}) (map[string]int, error) {
+	m, ok := v.(bson.M)
+	if !ok {
+		return nil, fmt.Errorf("weights must be a document")
+	}
+
+	w := make(map[string]int, len(m))
+	for k, v := range m {
+		n, ok := v.(int)
+		if !ok {
+			return nil, fmt.Errorf("weight of %s is not an integer", k)
+		}
+		w[k] = n
+	}
+
+	return w, nil
 }
 
 // BuildIndex build an mgo Index using the values of a ParsedIndex
@@ -122,7 +510,12 @@ func BuildIndex(p ParsedIndex) *mgo.Index {
 	}
 
 	for i := range p.Options {
-		switch p.Options[i] {
+		name, value := p.Options[i], ""
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value = name[:j], name[j+1:]
+		}
+
+		switch name {
 		case "unique":
 			idx.Unique = true
 		case "dropdups":
@@ -131,40 +524,34 @@ func BuildIndex(p ParsedIndex) *mgo.Index {
 			idx.Background = true
 		case "sparse":
 			idx.Sparse = true
+		case "expireafter":
+			idx.ExpireAfter, _ = time.ParseDuration(value)
+		case "name":
+			idx.Name = value
+		case "partial":
+			if v, err := parseIndexValue(value); err == nil {
+				idx.PartialFilter, _ = v.(bson.M)
+			}
+		case "weights":
+			if v, err := parseIndexValue(value); err == nil {
+				idx.Weights, _ = toWeights(v)
+			}
+		case "collation":
+			if !strings.HasPrefix(value, "{") {
+				idx.Collation = &mgo.Collation{Locale: value}
+				break
+			}
+			if v, err := parseIndexValue(value); err == nil {
+				idx.Collation, _ = toCollation(v)
+			}
 		}
 	}
 
 	return idx
 }
 
-func (p *ParsedIndex) appendOption(o string) {
-	o = strings.ToLower(o)
-	o = strings.Trim(o, " ")
-	for i := range optionKeywords {
-		if optionKeywords[i] == o {
-			p.Options = append(p.Options, o)
-		}
-	}
-}
-
-func (p *ParsedIndex) appendField(f string) {
-	p.Fields = append(p.Fields, f)
-}
-func (p *ParsedIndex) appendDotField(f string) {
-	p.Fields[p.lastFieldIdx] = p.Fields[p.lastFieldIdx] + "." + f
-}
-func (p *ParsedIndex) updateLastFieldIdx() {
-	if len(p.Fields) > 0 {
-		p.lastFieldIdx = len(p.Fields) - 1
-		return
-	}
-
-	p.lastFieldIdx = 0
-}
-func (p *ParsedIndex) setStickyFieldTo(s bool) {
-	p.stickyField = s
-}
-
-func (p *ParsedIndex) getStickyField() bool {
-	return p.stickyField
+// parseIndexValue parses an option value stored in ParsedIndex
+func parseIndexValue(src string) (interface{}, error) {
+	p := &idxParser{src: src}
+	return p.value()
 }
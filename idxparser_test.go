@@ -2,16 +2,18 @@ package mogo
 
 import (
 	"testing"
+	"time"
 
 	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 func TestScan(t *testing.T) {
 	Convey("should return the tokens in passed []byte", t, func() {
 		r := []ParsedIndex{
-			ParsedIndex{[]string{"name", "surname"}, []string{"unique", "sparse"}, 0, false},
-			ParsedIndex{[]string{"surname"}, []string{"unique"}, 0, false},
+			ParsedIndex{Fields: []string{"name", "surname"}, Options: []string{"unique", "sparse"}},
+			ParsedIndex{Fields: []string{"surname"}, Options: []string{"unique"}},
 		}
 		p := IndexScan("{name,surname},unique,sparse;{surname},unique")
 		So(p, ShouldResemble, r)
@@ -28,3 +30,88 @@ func TestBuildIndex(t *testing.T) {
 		}
 	})
 }
+
+func TestParseIndex(t *testing.T) {
+	Convey("should parse the extended idx grammar", t, func() {
+		p, err := ParseIndex(" {-age, address.city} , Unique ; {$text:title,$text:body},weights={title:3},name=search;{$hashed:code};{$2dsphere:loc}")
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, []ParsedIndex{
+			{Fields: []string{"-age", "address.city"}, Options: []string{"unique"}},
+			{Fields: []string{"$text:title", "$text:body"}, Options: []string{"weights={title:3}", "name=search"}},
+			{Fields: []string{"$hashed:code"}},
+			{Fields: []string{"$2dsphere:loc"}},
+		})
+
+		p, err = ParseIndex("{created},expireAfter=24h;{email},unique,partial={email:{$exists:true}},collation={locale:fr,strength:2}")
+		So(err, ShouldBeNil)
+		So(p[0].Options, ShouldResemble, []string{"expireafter=24h"})
+		So(p[1].Options, ShouldResemble, []string{"unique", "partial={email:{$exists:true}}", "collation={locale:fr,strength:2}"})
+
+		p, err = ParseIndex("")
+		So(err, ShouldBeNil)
+		So(p, ShouldBeNil)
+	})
+
+	Convey("should return the position of syntax errors", t, func() {
+		tests := []struct {
+			src string
+			pos int
+		}{
+			{"{}", 1},
+			{"{name", 5},
+			{"{name},uniq", 7},
+			{"{name},unique;{surname}x", 23},
+			{"{$fulltext:name}", 1},
+			{"{name..first}", 1},
+			{"{date},expireAfter=1day", 19},
+			{"{title},weights={title:high}", 16},
+			{"{name},partial=name", 15},
+			{"{name},collation={strength:2}", 17},
+			{"{name},partial={name:'a}", 21},
+		}
+		for _, test := range tests {
+			_, err := ParseIndexStrict(test.src)
+			So(err, ShouldHaveSameTypeAs, &IndexSyntaxError{})
+			So(err.(*IndexSyntaxError).Pos, ShouldEqual, test.pos)
+		}
+	})
+
+	Convey("should skip the unknown options out of strict mode", t, func() {
+		p, err := ParseIndex("{name},uniq,unique,fill={a:1};{surname}")
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, []ParsedIndex{
+			{Fields: []string{"name"}, Options: []string{"unique"}},
+			{Fields: []string{"surname"}},
+		})
+
+		_, ignored, err := parseIndex("{name},uniq,unique,fill={a:1}", false)
+		So(err, ShouldBeNil)
+		So(len(ignored), ShouldEqual, 2)
+		So(ignored[0].Pos, ShouldEqual, 7)
+		So(ignored[1].Pos, ShouldEqual, 19)
+
+		_, err = ParseIndex("{name},fill={a:1")
+		So(err, ShouldHaveSameTypeAs, &IndexSyntaxError{})
+	})
+}
+
+func TestBuildIndexOptions(t *testing.T) {
+	Convey("should map the options onto the Index fields", t, func() {
+		p := IndexScan("{-age},unique,sparse,background,dropdups,name=by_age;{created},expireafter=1h30m;{$text:title,$text:body},weights={title:3,body:1}")
+		idx := BuildIndex(p[0])
+		So(idx.Key, ShouldResemble, []string{"-age"})
+		So(idx.Unique && idx.Sparse && idx.Background && idx.DropDups, ShouldBeTrue)
+		So(idx.Name, ShouldEqual, "by_age")
+		So(BuildIndex(p[1]).ExpireAfter, ShouldEqual, 90*time.Minute)
+		So(BuildIndex(p[2]).Weights, ShouldResemble, map[string]int{"title": 3, "body": 1})
+
+		p = IndexScan(`{email},partial={email:{$exists:true},age:{$gt:18},tags:["a",'b c']};{name},collation={locale:fr,strength:2,numericOrdering:true};{name},collation=en`)
+		So(BuildIndex(p[0]).PartialFilter, ShouldResemble, bson.M{
+			"email": bson.M{"$exists": true},
+			"age":   bson.M{"$gt": 18},
+			"tags":  []interface{}{"a", "b c"},
+		})
+		So(BuildIndex(p[1]).Collation, ShouldResemble, &mgo.Collation{Locale: "fr", Strength: 2, NumericOrdering: true})
+		So(BuildIndex(p[2]).Collation, ShouldResemble, &mgo.Collation{Locale: "en"})
+	})
+}
@@ -0,0 +1,230 @@
+package mogo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/globalsign/mgo"
+)
+
+// IndexChanges are the differences between the indexes declared by the models
+// stored in a collection (idx tags) and the indexes of the collection
+type IndexChanges struct {
+	Collection string
+	// Create are the declared indexes missing in the collection
+	Create []mgo.Index
+	// Drop are the collection indexes not declared by any model
+	Drop []mgo.Index
+}
+
+// SyncOptions selects the changes applied by SyncIndexes, with the zero
+// value the changes are only reported
+type SyncOptions struct {
+	Create bool
+	Drop   bool
+}
+
+// SyncIndexes compares the indexes declared by the registered models with the
+// indexes of their collections and returns the changes, applying them as
+// selected by opts. An index declared with different options (i.e. unique), or
+// with a name option different from the existing index name, is dropped and
+// created again. It should be called once at startup, Save does not create the
+// indexes.
+func (r ModelReg) SyncIndexes(conn *Connection, opts SyncOptions) ([]IndexChanges, error) {
+	sess := conn.Session.Clone()
+	defer sess.Close()
+	db := sess.DB(conn.Config.Database)
+
+	declared := r.declaredIndexes()
+	colls := make([]string, 0, len(declared))
+	for c := range declared {
+		colls = append(colls, c)
+	}
+	sort.Strings(colls)
+
+	changes := []IndexChanges{}
+	for _, c := range colls {
+		col := db.C(c)
+
+		existing, err := col.Indexes()
+		if err != nil && !isNamespaceNotFound(err) {
+			return changes, err
+		}
+
+		ch := IndexChanges{Collection: c}
+		ch.Create, ch.Drop = diffIndexes(declared[c], existing)
+		if len(ch.Create) == 0 && len(ch.Drop) == 0 {
+			continue
+		}
+		changes = append(changes, ch)
+
+		if opts.Drop {
+			for _, idx := range ch.Drop {
+				err = col.DropIndexName(idx.Name)
+				if err != nil {
+					return changes, err
+				}
+			}
+		}
+
+		if opts.Create {
+			for _, idx := range ch.Create {
+				err = col.EnsureIndex(idx)
+				if err != nil {
+					return changes, err
+				}
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// declaredIndexes returns the indexes declared by the models, by collection
+func (r ModelReg) declaredIndexes() map[string][]mgo.Index {
+	declared := make(map[string][]mgo.Index)
+
+	for _, ri := range r.reg() {
+		seen := map[string]bool{}
+		for _, idx := range declared[ri.Collection] {
+			seen[indexSignature(idx)+"|name="+idx.Name] = true
+		}
+
+		if _, ok := declared[ri.Collection]; !ok {
+			declared[ri.Collection] = []mgo.Index{}
+		}
+
+		for _, pis := range ri.Indexes {
+			for i := range pis {
+				idx := *BuildIndex(pis[i])
+				if s := indexSignature(idx) + "|name=" + idx.Name; !seen[s] {
+					seen[s] = true
+					declared[ri.Collection] = append(declared[ri.Collection], idx)
+				}
+			}
+		}
+	}
+
+	for _, idxs := range declared {
+		sort.Slice(idxs, func(i, j int) bool {
+			return indexSignature(idxs[i]) < indexSignature(idxs[j])
+		})
+	}
+
+	return declared
+}
+
+// diffIndexes returns the declared indexes missing in existing and the existing
+// indexes not declared (the _id index is never dropped)
+func diffIndexes(declared []mgo.Index, existing []mgo.Index) (create []mgo.Index, drop []mgo.Index) {
+	wanted := make([]bool, len(existing))
+	for _, idx := range declared {
+		found := false
+		for i := range existing {
+			if sameIndex(idx, existing[i]) {
+				found = true
+				wanted[i] = true
+			}
+		}
+
+		if !found {
+			create = append(create, idx)
+		}
+	}
+
+	for i, idx := range existing {
+		if idx.Name == "_id_" {
+			continue
+		}
+		if !wanted[i] {
+			drop = append(drop, idx)
+		}
+	}
+
+	return create, drop
+}
+
+// sameIndex returns true if the existing index is the declared one: same
+// signature and, if the idx tag declares it, same name
+func sameIndex(declared mgo.Index, existing mgo.Index) bool {
+	if declared.Name != "" && declared.Name != existing.Name {
+		return false
+	}
+
+	return indexSignature(declared) == indexSignature(existing)
+}
+
+// indexSignature identifies an index by its key and by the options stored
+// in database (background and dropdups are only build options). The text
+// keys and the collation are normalized as returned by the server.
+func indexSignature(idx mgo.Index) string {
+	key, text := []string{}, []string{}
+	for _, k := range idx.Key {
+		if strings.HasPrefix(k, "$text:") {
+			text = append(text, k)
+			continue
+		}
+		key = append(key, k)
+	}
+	sort.Strings(text)
+
+	s := strings.Join(append(key, text...), ",")
+	if idx.Unique {
+		s += "|unique"
+	}
+	if idx.Sparse {
+		s += "|sparse"
+	}
+	if idx.ExpireAfter != 0 {
+		s += fmt.Sprintf("|expire=%v", idx.ExpireAfter)
+	}
+	if idx.PartialFilter != nil {
+		s += fmt.Sprintf("|partial=%v", idx.PartialFilter)
+	}
+	if idx.Collation != nil {
+		s += fmt.Sprintf("|collation=%v", normalizeCollation(*idx.Collation))
+	}
+	if len(text) > 0 {
+		// text fields without weight have weight 1
+		weights := make(map[string]int, len(text))
+		for _, k := range text {
+			weights[k[len("$text:"):]] = 1
+		}
+		for f, w := range idx.Weights {
+			weights[f] = w
+		}
+		s += fmt.Sprintf("|weights=%v", weights)
+	} else if len(idx.Weights) > 0 {
+		s += fmt.Sprintf("|weights=%v", idx.Weights)
+	}
+
+	return s
+}
+
+// normalizeCollation sets the defaults of the server in the empty fields
+func normalizeCollation(c mgo.Collation) mgo.Collation {
+	if c.CaseFirst == "" {
+		c.CaseFirst = "off"
+	}
+	if c.Strength == 0 {
+		c.Strength = 3
+	}
+	if c.Alternate == "" {
+		c.Alternate = "non-ignorable"
+	}
+	if c.MaxVariable == "" {
+		c.MaxVariable = "punct"
+	}
+
+	return c
+}
+
+// isNamespaceNotFound returns true for the error of a missing collection
+func isNamespaceNotFound(err error) bool {
+	if qe, ok := err.(*mgo.QueryError); ok && qe.Code == 26 {
+		return true
+	}
+
+	return strings.Contains(err.Error(), "ns not found")
+}
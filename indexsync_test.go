@@ -0,0 +1,120 @@
+package mogo
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type Indexed struct {
+	DocumentModel `bson:",inline" coll:"indexed" idx:"{name,age},unique"`
+	Name          string `idx:"{name}"`
+	Age           int
+}
+
+func TestDiffIndexes(t *testing.T) {
+	Convey("diffIndexes", t, func() {
+		declared := []mgo.Index{
+			{Key: []string{"name"}},
+			{Key: []string{"name", "age"}, Unique: true},
+		}
+		existing := []mgo.Index{
+			{Key: []string{"_id"}, Name: "_id_"},
+			{Key: []string{"name"}, Name: "name_1", Background: true},
+			{Key: []string{"name", "age"}, Name: "name_1_age_1"},
+			{Key: []string{"old"}, Name: "old_1"},
+		}
+
+		create, drop := diffIndexes(declared, existing)
+		So(create, ShouldResemble, []mgo.Index{{Key: []string{"name", "age"}, Unique: true}})
+		So(drop, ShouldResemble, []mgo.Index{
+			{Key: []string{"name", "age"}, Name: "name_1_age_1"},
+			{Key: []string{"old"}, Name: "old_1"},
+		})
+
+		create, drop = diffIndexes(declared, nil)
+		So(len(create), ShouldEqual, 2)
+		So(drop, ShouldBeEmpty)
+
+		// text indexes and collations as returned by the server
+		declared = []mgo.Index{
+			{Key: []string{"$text:title", "$text:body"}, Weights: map[string]int{"title": 3}},
+			{Key: []string{"name"}, Collation: &mgo.Collation{Locale: "fr"}},
+		}
+		existing = []mgo.Index{
+			{Key: []string{"$text:body", "$text:title"}, Name: "text", Weights: map[string]int{"body": 1, "title": 3}},
+			{Key: []string{"name"}, Name: "name_1", Collation: &mgo.Collation{Locale: "fr", CaseFirst: "off", Strength: 3, Alternate: "non-ignorable", MaxVariable: "punct"}},
+		}
+		create, drop = diffIndexes(declared, existing)
+		So(create, ShouldBeEmpty)
+		So(drop, ShouldBeEmpty)
+
+		// the name is compared only if declared
+		declared = []mgo.Index{
+			{Key: []string{"name"}, Name: "by_name"},
+			{Key: []string{"age"}},
+		}
+		existing = []mgo.Index{
+			{Key: []string{"name"}, Name: "name_1"},
+			{Key: []string{"age"}, Name: "age_idx"},
+		}
+		create, drop = diffIndexes(declared, existing)
+		So(create, ShouldResemble, []mgo.Index{{Key: []string{"name"}, Name: "by_name"}})
+		So(drop, ShouldResemble, []mgo.Index{{Key: []string{"name"}, Name: "name_1"}})
+	})
+}
+
+func TestSyncIndexes(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	ModelRegistry.Register(Indexed{})
+
+	Convey("SyncIndexes", t, func() {
+		col := conn.Session.DB("mogotest").C("indexed")
+		col.DropCollection()
+
+		changes := func() IndexChanges {
+			chs, err := ModelRegistry.SyncIndexes(conn, SyncOptions{})
+			So(err, ShouldBeNil)
+			for _, ch := range chs {
+				if ch.Collection == "indexed" {
+					return ch
+				}
+			}
+			return IndexChanges{Collection: "indexed"}
+		}
+
+		Convey("should report the missing indexes without creating them", func() {
+			ch := changes()
+			So(len(ch.Create), ShouldEqual, 2)
+			So(ch.Drop, ShouldBeEmpty)
+
+			d := NewDoc(Indexed{Name: "a"}).(*Indexed)
+			So(Save(d), ShouldBeNil)
+			idxs, _ := col.Indexes()
+			So(len(idxs), ShouldEqual, 1)
+		})
+
+		Convey("should create and drop the indexes", func() {
+			_, err := ModelRegistry.SyncIndexes(conn, SyncOptions{Create: true})
+			So(err, ShouldBeNil)
+			So(changes().Create, ShouldBeEmpty)
+
+			So(col.EnsureIndexKey("age"), ShouldBeNil)
+			ch := changes()
+			So(len(ch.Drop), ShouldEqual, 1)
+			So(ch.Drop[0].Name, ShouldEqual, "age_1")
+
+			_, err = ModelRegistry.SyncIndexes(conn, SyncOptions{Drop: true})
+			So(err, ShouldBeNil)
+			ch = changes()
+			So(ch.Create, ShouldBeEmpty)
+			So(ch.Drop, ShouldBeEmpty)
+
+			idxs, _ := col.Indexes()
+			So(len(idxs), ShouldEqual, 3)
+		})
+	})
+}
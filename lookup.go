@@ -0,0 +1,291 @@
+package mogo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// withField is the field of the aggregation results containing the joined documents
+const withField = "_with"
+
+var errWithPagination = errors.New("the ref fields joined by With cannot be paginated")
+
+// join is a ref field joined by $lookup
+type join struct {
+	field string
+	ref   RefIndex
+
+	// collection and soft delete flag of the referenced model
+	coll       string
+	softDelete bool
+}
+
+// With makes the query load the documents referenced by the passed ref fields
+// in the same round trip, using an aggregation with a $lookup stage for each
+// field (and an $unwind stage for the single references). The joined documents
+// are decoded into the referenced model and stored in the companion fields
+// (populate tag) as PopulateAll does, i.e.:
+//
+//	parrots := []*Parrot{}
+//	err := mogo.Find(&Parrot{}, nil).Sort("name").With("Friends", "BestFriend").All(&parrots)
+//
+// The filter, sort, skip, limit and projection of the query are compiled into
+// the aggregation, the other options (i.e. Hint, Batch) are ignored and the
+// query cannot be paginated. The referenced documents must be stored in the
+// database of the query, the soft deleted ones are not joined.
+func (q *Query) With(fields ...string) *Query {
+	q.with = append(q.with, fields...)
+
+	return q
+}
+
+// oneWith loads the first document of the With aggregation in d
+func (q *Query) oneWith(d Document) error {
+	iter := q.Iter()
+	found := iter.Next(d)
+
+	err := iter.Close()
+	if iter.Err != nil {
+		return iter.Err
+	}
+	if err != nil {
+		return err
+	}
+	if !found {
+		return mgo.ErrNotFound
+	}
+
+	return nil
+}
+
+// lookupStages returns the aggregation pipeline loading the documents of the
+// query, of the same model of d, with the joined ref fields
+func (q *Query) lookupStages(d Document) ([]bson.M, []join, error) {
+	reg := q.connection().Models()
+	iname, _ := d.GetMe()
+	n, ri, ok := reg.ExistsByName(iname)
+	if !ok {
+		return nil, nil, fmt.Errorf("the document model is not registered (name: %s)", iname)
+	}
+
+	joins := make([]join, 0, len(q.with))
+	for _, f := range q.with {
+		r, ok := ri.Refs[f]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is not a ref field (model: %s)", f, n)
+		}
+		if !r.Exists {
+			return nil, nil, fmt.Errorf("the referenced model %s is not registered (field: %s)", r.Ref, f)
+		}
+		if r.Target == "" {
+			return nil, nil, fmt.Errorf("%s has no populate field (model: %s)", f, n)
+		}
+
+		m := reg.Model(r.Ref)
+		joins = append(joins, join{field: f, ref: r, coll: m.Collection, softDelete: m.SoftDelete})
+	}
+
+	stages := []bson.M{}
+	if f := q.filter(); f != nil {
+		stages = append(stages, bson.M{"$match": f})
+	}
+	if len(q.sort) > 0 {
+		stages = append(stages, bson.M{"$sort": sortKeys(q.sort)})
+	}
+	if q.skip > 0 {
+		stages = append(stages, bson.M{"$skip": q.skip})
+	}
+	if q.limit > 0 {
+		stages = append(stages, bson.M{"$limit": q.limit})
+	}
+
+	include := false
+	for _, v := range q.projection {
+		include = include || v == 1
+	}
+
+	for _, j := range joins {
+		path, err := GetBsonPath(ri.Type, j.field)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		as := withField + "." + j.field
+		stages = append(stages, bson.M{"$lookup": bson.M{
+			"from":         j.coll,
+			"localField":   path + "._id",
+			"foreignField": "_id",
+			"as":           as,
+		}})
+		if j.ref.Kind != reflect.Slice {
+			stages = append(stages, bson.M{"$unwind": bson.M{"path": "$" + as, "preserveNullAndEmptyArrays": true}})
+		}
+	}
+
+	if len(q.projection) > 0 {
+		project := bson.M{}
+		for k, v := range q.projection {
+			project[k] = v
+		}
+		// the ref fields keep the order of the joined documents
+		if include {
+			project[withField] = 1
+			for _, j := range joins {
+				path, _ := GetBsonPath(ri.Type, j.field)
+				project[path] = 1
+			}
+		}
+		stages = append(stages, bson.M{"$project": project})
+	}
+
+	return stages, joins, nil
+}
+
+// sortKeys returns the $sort stage document of the mgo.Query.Sort fields
+func sortKeys(fields []string) bson.D {
+	keys := bson.D{}
+	for _, f := range fields {
+		order := 1
+		switch {
+		case strings.HasPrefix(f, "-"):
+			order, f = -1, f[1:]
+		case strings.HasPrefix(f, "+"):
+			f = f[1:]
+		}
+		keys = append(keys, bson.DocElem{Name: f, Value: order})
+	}
+
+	return keys
+}
+
+// lookup starts the With aggregation of the query, d is the first document loaded
+func (i *Iter) lookup(d Document) error {
+	q := i.query
+	if q == nil || len(q.with) == 0 {
+		return errors.New("the iterator is not initialized")
+	}
+
+	stages, joins, err := q.lookupStages(d)
+	if err != nil {
+		return err
+	}
+
+	i.joins = joins
+	i.MgoI = q.MgoC.Pipe(stages).Iter()
+
+	return nil
+}
+
+// nextJoined loads the next document of the With aggregation in op.Doc with
+// its joined documents, it returns mgo.ErrNotFound when the iterator is exhausted
+func (i *Iter) nextJoined(op *Operation, iname string, b docBinding) error {
+	var raw bson.Raw
+
+	if !i.MgoI.Next(&raw) {
+		if i.MgoI.Timeout() {
+			i.Timeout = true
+			return mgo.ErrNotFound
+		}
+
+		if err := i.MgoI.Err(); err != nil {
+			return err
+		}
+		return mgo.ErrNotFound
+	}
+
+	err := raw.Unmarshal(op.Doc)
+	op.Doc.SetMe(iname, op.Doc)
+	b.apply(op.Doc)
+	if err != nil {
+		return err
+	}
+
+	return i.setJoined(op.Doc, raw)
+}
+
+// setJoined fills the companion fields of doc with the joined documents of raw
+func (i *Iter) setJoined(doc Document, raw bson.Raw) error {
+	var res struct {
+		With map[string]bson.Raw `bson:"_with"`
+	}
+
+	if err := raw.Unmarshal(&res); err != nil {
+		return err
+	}
+
+	reg := registryOf(doc)
+	v := reflect.ValueOf(doc).Elem()
+	for _, j := range i.joins {
+		items := []bson.Raw{}
+		if jr, ok := res.With[j.field]; ok {
+			if j.ref.Kind == reflect.Slice {
+				if err := jr.Unmarshal(&items); err != nil {
+					return err
+				}
+			} else {
+				items = append(items, jr)
+			}
+		}
+
+		docs := make(map[bson.ObjectId]Document, len(items))
+		for _, item := range items {
+			d, err := i.joined(doc, j, item)
+			if err != nil {
+				return err
+			}
+			if d != nil {
+				docs[d.GetID()] = d
+			}
+		}
+
+		err := setPopulated(v.FieldByName(j.ref.Target), refIDs(v.Field(j.ref.Idx)), docs, reg.TypeOf(j.ref.Ref))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// joined decodes the document of the ref field j joined to parent, it returns
+// nil for a soft deleted document
+func (i *Iter) joined(parent Document, j join, item bson.Raw) (Document, error) {
+	if j.softDelete {
+		var sd struct {
+			Deleted *time.Time `bson:"_deleted"`
+		}
+		if err := item.Unmarshal(&sd); err != nil {
+			return nil, err
+		}
+		if sd.Deleted != nil {
+			return nil, nil
+		}
+	}
+
+	d := registryOf(parent).New(j.ref.Ref).(Document)
+	bindLike(d, parent)
+	iname, _ := d.GetMe()
+	b := bindingOf(d)
+
+	op := i.query.operation(d)
+	op.Hook.Collection = i.query.MgoC.Database.C(j.coll)
+	err := runPipeline(i.connection(), op, func(op *Operation) error {
+		err := item.Unmarshal(op.Doc)
+		op.Doc.SetMe(iname, op.Doc)
+		b.apply(op.Doc)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	loaded(op.Doc)
+	return op.Doc, nil
+}
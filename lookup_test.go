@@ -0,0 +1,121 @@
+package mogo
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLookupStages(t *testing.T) {
+	Convey("lookupStages", t, func() {
+		ModelRegistry.Register(Parrot{}, Canary{}, Note{}, Notebook{})
+		d := NewDoc(Parrot{}).(*Parrot)
+
+		q := &Query{MgoC: &mgo.Collection{Name: "parrots"}, Query: bson.M{"name": "p1"}}
+		q.sort = []string{"-name", "_id"}
+		q.limit = 2
+		q.With("Friends", "BestFriend")
+
+		stages, joins, err := q.lookupStages(d)
+		So(err, ShouldBeNil)
+		So(len(joins), ShouldEqual, 2)
+		So(joins[0].coll, ShouldEqual, "canaries")
+		So(stages, ShouldResemble, []bson.M{
+			{"$match": bson.M{"name": "p1"}},
+			{"$sort": bson.D{{Name: "name", Value: -1}, {Name: "_id", Value: 1}}},
+			{"$limit": 2},
+			{"$lookup": bson.M{"from": "canaries", "localField": "friends._id", "foreignField": "_id", "as": "_with.Friends"}},
+			{"$lookup": bson.M{"from": "canaries", "localField": "bestfriend._id", "foreignField": "_id", "as": "_with.BestFriend"}},
+			{"$unwind": bson.M{"path": "$_with.BestFriend", "preserveNullAndEmptyArrays": true}},
+		})
+
+		Convey("should keep the ref fields in the projection", func() {
+			q.projection = bson.M{"name": 1}
+			q.with = []string{"BestFriend"}
+
+			stages, _, err := q.lookupStages(d)
+			So(err, ShouldBeNil)
+			So(stages[len(stages)-1], ShouldResemble, bson.M{"$project": bson.M{"name": 1, "_with": 1, "bestfriend": 1}})
+		})
+
+		Convey("should return an error for fields which are not ref fields", func() {
+			q.with = []string{"Name"}
+			_, _, err := q.lookupStages(d)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should return an error for ref fields without populate field", func() {
+			q := &Query{MgoC: &mgo.Collection{Name: "notebooks"}}
+			q.With("Notes")
+			_, _, err := q.lookupStages(NewDoc(Notebook{}).(*Notebook))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestWith(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	ModelRegistry.Register(Parrot{}, Canary{})
+
+	Convey("With", t, func() {
+		canaries := make([]*Canary, 3)
+		for i := range canaries {
+			canaries[i] = NewDoc(Canary{Name: string(rune('a' + i))}).(*Canary)
+			So(Save(canaries[i]), ShouldBeNil)
+		}
+
+		p1 := NewDoc(Parrot{
+			Name:       "p1",
+			Friends:    RefFieldSlice{{ID: canaries[2].ID}, {ID: bson.NewObjectId()}, {ID: canaries[0].ID}},
+			BestFriend: RefField{ID: canaries[1].ID},
+		}).(*Parrot)
+		So(Save(p1), ShouldBeNil)
+
+		p2 := NewDoc(Parrot{Name: "p2", Friends: RefFieldSlice{{ID: canaries[1].ID}}}).(*Parrot)
+		So(Save(p2), ShouldBeNil)
+
+		Convey("should load the referenced documents in the companion fields", func() {
+			parrots := []*Parrot{}
+			err := p1.Find(nil).Sort("name").With("Friends", "BestFriend").All(&parrots)
+			So(err, ShouldBeNil)
+			So(len(parrots), ShouldEqual, 2)
+
+			So(len(parrots[0].FriendsDocs), ShouldEqual, 2)
+			So(parrots[0].FriendsDocs[0].Name, ShouldEqual, "c")
+			So(parrots[0].FriendsDocs[1].Name, ShouldEqual, "a")
+			So(parrots[0].FriendsDocs[0].IsNew(), ShouldBeFalse)
+			So(parrots[0].BestFriendDoc.Name, ShouldEqual, "b")
+			So(parrots[0].BestFriendDoc.AsDocument(), ShouldEqual, &parrots[0].BestFriendDoc)
+
+			So(parrots[1].FriendsDocs[0].Name, ShouldEqual, "b")
+			So(parrots[1].BestFriendDoc.ID, ShouldEqual, "")
+		})
+
+		Convey("should load a single document", func() {
+			p := NewDoc(Parrot{}).(*Parrot)
+			err := p1.Find(bson.M{"name": "p2"}).With("Friends").One(p)
+			So(err, ShouldBeNil)
+			So(p.ID, ShouldEqual, p2.ID)
+			So(p.IsNew(), ShouldBeFalse)
+			So(p.FriendsDocs[0].ID, ShouldEqual, canaries[1].ID)
+
+			err = p1.Find(bson.M{"name": "p3"}).With("Friends").One(p)
+			So(err, ShouldEqual, mgo.ErrNotFound)
+		})
+
+		Convey("should not paginate the query", func() {
+			parrots := []*Parrot{}
+			iter := p1.Find(nil).With("Friends").Paginate(1).Iter()
+			So(iter.NextPage(&parrots), ShouldBeFalse)
+			So(iter.Err, ShouldEqual, errWithPagination)
+		})
+
+		Reset(func() {
+			conn.Session.DB("mogotest").DropDatabase()
+		})
+	})
+}
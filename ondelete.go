@@ -0,0 +1,202 @@
+package mogo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// Policies for the ondelete tag of the ref fields, applied when the
+// referenced document is removed with Remove or RemoveAll:
+//
+//	cascade:  the referencing documents are removed too
+//	nullify:  the reference is removed from the referencing documents
+//	restrict: the document can not be removed while it is referenced (see RestrictError)
+const (
+	OnDeleteCascade  = "cascade"
+	OnDeleteNullify  = "nullify"
+	OnDeleteRestrict = "restrict"
+)
+
+// BlockingRef is a document which references, with the restrict policy,
+// a document that is going to be removed
+type BlockingRef struct {
+	Model string
+	Field string
+	ID    bson.ObjectId
+}
+
+// RestrictError is returned by Remove and RemoveAll when the document is referenced
+// by other documents using the ondelete:"restrict" policy
+type RestrictError struct {
+	Blocking []BlockingRef
+}
+
+func (e *RestrictError) Error() string {
+	refs := make([]string, len(e.Blocking))
+
+	for i, b := range e.Blocking {
+		refs[i] = fmt.Sprintf("%s.%s (%s)", b.Model, b.Field, b.ID.Hex())
+	}
+	return "Document is referenced by: " + strings.Join(refs, ", ")
+}
+
+// referrers returns the ref fields of all registered models which refer
+// to the model of doc using the passed policy
+func referrers(doc Document, policy string) []RefIndex {
+	var refs []RefIndex
+
+	reg := registryOf(doc)
+	n, _, ok := reg.Exists(doc)
+	if !ok {
+		return nil
+	}
+
+	for _, m := range reg {
+		for _, r := range m.Refs {
+			if r.Ref == n && r.OnDelete == policy {
+				refs = append(refs, r)
+			}
+		}
+	}
+
+	return refs
+}
+
+// refField returns the struct field of the ref field r referring to doc
+func refField(doc Document, r RefIndex) reflect.StructField {
+	return registryOf(doc).TypeOf(r.Model).Field(r.Idx)
+}
+
+// refSelector returns the selector matching the documents whose field r refers to doc
+func refSelector(doc Document, r RefIndex) bson.M {
+	return bson.M{GetBsonName(refField(doc, r)) + "._id": doc.GetID()}
+}
+
+// refModel returns a new document of the model owning the ref field r referring
+// to doc, bound to the connection and database of doc
+func refModel(doc Document, r RefIndex) Document {
+	d := registryOf(doc).New(r.Model).(Document)
+	bindLike(d, doc)
+
+	return d
+}
+
+// refCollection returns the mgo collection of the model owning r using the passed session
+func refCollection(sess *mgo.Session, doc Document, r RefIndex) *mgo.Collection {
+	return refModel(doc, r).GetColl().collectionOnSession(sess)
+}
+
+// checkRestrict returns a RestrictError if doc is referenced by other
+// documents using the restrict policy. If hard is true (doc is going to be
+// removed and not marked as deleted) the documents removed with it by the
+// cascade policy are checked too, down the whole cascade tree, so that no
+// document is removed when a restrict policy would stop the cascade.
+func checkRestrict(sess *mgo.Session, doc Document, hard bool) error {
+	var blocking []BlockingRef
+
+	err := restricted(sess, doc, hard, map[bson.ObjectId]bool{}, &blocking)
+	if err != nil {
+		return err
+	}
+
+	if len(blocking) > 0 {
+		return &RestrictError{Blocking: blocking}
+	}
+
+	return nil
+}
+
+// restricted appends to blocking the documents referencing doc, or a document
+// cascading from it if hard is true, with the restrict policy. seen holds the
+// documents already checked.
+func restricted(sess *mgo.Session, doc Document, hard bool, seen map[bson.ObjectId]bool, blocking *[]BlockingRef) error {
+	var ids []struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+
+	if seen[doc.GetID()] {
+		return nil
+	}
+	seen[doc.GetID()] = true
+
+	for _, r := range referrers(doc, OnDeleteRestrict) {
+		err := refCollection(sess, doc, r).Find(refSelector(doc, r)).Select(bson.M{"_id": 1}).All(&ids)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range ids {
+			*blocking = append(*blocking, BlockingRef{Model: r.Model, Field: refField(doc, r).Name, ID: d.ID})
+		}
+	}
+
+	if !hard {
+		return nil
+	}
+
+	for _, r := range referrers(doc, OnDeleteCascade) {
+		col := refCollection(sess, doc, r)
+		query := softFilter(registryOf(doc), col.Name, refSelector(doc, r), excludeDeleted)
+
+		err := col.Find(query).Select(bson.M{"_id": 1}).All(&ids)
+		if err != nil {
+			return err
+		}
+
+		// The cascade removes the referencing documents as Remove does
+		for _, d := range ids {
+			t := refModel(doc, r)
+			t.SetID(d.ID)
+
+			err = restricted(sess, t, !isSoftDelete(t), seen, blocking)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyOnDelete applies the cascade and nullify policies to the documents
+// referencing the removed document doc
+func applyOnDelete(sess *mgo.Session, doc Document) error {
+	id := doc.GetID()
+
+	for _, r := range referrers(doc, OnDeleteNullify) {
+		name := GetBsonName(refField(doc, r))
+		update := bson.M{"$unset": bson.M{name: ""}}
+		if r.Kind == reflect.Slice {
+			update = bson.M{"$pull": bson.M{name: bson.M{"_id": id}}}
+		}
+
+		_, err := refCollection(sess, doc, r).UpdateAll(refSelector(doc, r), update)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range referrers(doc, OnDeleteCascade) {
+		t := refModel(doc, r)
+		docs := reflect.New(reflect.SliceOf(reflect.PtrTo(registryOf(doc).TypeOf(r.Model))))
+
+		err := Find(t, refSelector(doc, r)).All(docs.Interface())
+		if err != nil {
+			return err
+		}
+
+		// Removed using hooks and policies of the referencing model
+		for i := 0; i < docs.Elem().Len(); i++ {
+			d := docs.Elem().Index(i).Interface().(Document)
+			if err = d.GetColl().Remove(d); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package mogo
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type Writer struct {
+	DocumentModel `bson:",inline" coll:"writers"`
+	Name          string
+}
+
+type Book struct {
+	DocumentModel `bson:",inline" coll:"books"`
+	Title         string
+	Writer        RefField `ref:"Writer" ondelete:"cascade"`
+}
+
+type Review struct {
+	DocumentModel `bson:",inline" coll:"reviews"`
+	Book          RefField      `ref:"Book" ondelete:"nullify"`
+	Related       RefFieldSlice `ref:"Book" ondelete:"nullify"`
+}
+
+type Contract struct {
+	DocumentModel `bson:",inline" coll:"contracts"`
+	Writer        RefField `ref:"Writer" ondelete:"restrict"`
+}
+
+type Loan struct {
+	DocumentModel `bson:",inline" coll:"loans"`
+	Book          RefField `ref:"Book" ondelete:"restrict"`
+}
+
+type BadPolicy struct {
+	DocumentModel `bson:",inline" coll:"bad-policy"`
+	Writer        RefField `ref:"Writer" ondelete:"explode"`
+}
+
+func TestOnDelete(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	ModelRegistry.Register(Writer{}, Book{}, Review{}, Contract{}, Loan{})
+
+	Convey("should record the ondelete policy and panic on unknown ones", t, func() {
+		So(ModelRegistry.Model("Book").Refs["Writer"].OnDelete, ShouldEqual, OnDeleteCascade)
+		So(ModelRegistry.Model("Review").Refs["Related"].OnDelete, ShouldEqual, OnDeleteNullify)
+		So(func() { ModelRegistry.Register(BadPolicy{}) }, ShouldPanic)
+	})
+
+	Convey("Referential integrity on delete", t, func() {
+		w := NewDoc(Writer{Name: "w"}).(*Writer)
+		So(Save(w), ShouldBeNil)
+
+		b1 := NewDoc(Book{Title: "b1", Writer: RefField{ID: w.ID}}).(*Book)
+		So(Save(b1), ShouldBeNil)
+		b2 := NewDoc(Book{Title: "b2", Writer: RefField{ID: w.ID}}).(*Book)
+		So(Save(b2), ShouldBeNil)
+
+		r := NewDoc(Review{
+			Book:    RefField{ID: b1.ID},
+			Related: RefFieldSlice{{ID: b1.ID}, {ID: b2.ID}},
+		}).(*Review)
+		So(Save(r), ShouldBeNil)
+
+		Convey("should not remove a document referenced with the restrict policy", func() {
+			c := NewDoc(Contract{Writer: RefField{ID: w.ID}}).(*Contract)
+			So(Save(c), ShouldBeNil)
+
+			err := w.Remove()
+			So(err, ShouldNotBeNil)
+			rerr, ok := err.(*RestrictError)
+			So(ok, ShouldBeTrue)
+			So(rerr.Blocking, ShouldResemble, []BlockingRef{{Model: "github.com/goonode/mogo.Contract", Field: "Writer", ID: c.ID}})
+
+			count, _ := w.GetColl().C().FindId(w.ID).Count()
+			So(count, ShouldEqual, 1)
+
+			errs := RemoveAll([]Document{w})
+			So(errs[w.ID], ShouldHaveSameTypeAs, &RestrictError{})
+		})
+
+		Convey("should not remove anything when the cascade reaches a restricted document", func() {
+			l := NewDoc(Loan{Book: RefField{ID: b2.ID}}).(*Loan)
+			So(Save(l), ShouldBeNil)
+
+			err := w.Remove()
+			So(err, ShouldNotBeNil)
+			rerr, ok := err.(*RestrictError)
+			So(ok, ShouldBeTrue)
+			So(rerr.Blocking, ShouldResemble, []BlockingRef{{Model: "github.com/goonode/mogo.Loan", Field: "Book", ID: l.ID}})
+
+			count, _ := w.GetColl().C().FindId(w.ID).Count()
+			So(count, ShouldEqual, 1)
+			count, _ = b1.GetColl().C().Find(bson.M{"writer._id": w.ID}).Count()
+			So(count, ShouldEqual, 2)
+
+			f := NewDoc(Review{}).(*Review)
+			err = f.FindByID(r.ID, f)
+			So(err, ShouldBeNil)
+			So(f.Book.ID, ShouldEqual, b1.ID)
+		})
+
+		Convey("should cascade the removal and nullify the references", func() {
+			err := Remove(w)
+			So(err, ShouldBeNil)
+
+			count, _ := b1.GetColl().C().Find(bson.M{"writer._id": w.ID}).Count()
+			So(count, ShouldEqual, 0)
+
+			f := NewDoc(Review{}).(*Review)
+			err = f.FindByID(r.ID, f)
+			So(err, ShouldBeNil)
+			So(f.Book.ID.Valid(), ShouldBeFalse)
+			So(len(f.Related), ShouldEqual, 0)
+		})
+
+		Reset(func() {
+			conn.Session.DB("mogotest").DropDatabase()
+		})
+	})
+}
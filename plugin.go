@@ -0,0 +1,191 @@
+package mogo
+
+import (
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// Operation is the operation passed through the plugin pipeline. Plugins can
+// change the document, the query or the selector before calling the next handler.
+type Operation struct {
+	// Hook is the context passed to the document hooks (Context, session
+	// collection and operation kind)
+	Hook *HookContext
+
+	// Model is the registry name of the model (see ModelReg.ExistsByName),
+	// empty if unknown
+	Model string
+
+	// Doc is the document saved, removed or loaded. It is nil for the
+	// selector based removals, and it is set to nil by Query.Apply when no
+	// document is loaded
+	Doc Document
+
+	// Query is the query loading Doc (Query.One, Query.Apply and Iter.Next)
+	Query *Query
+
+	// Selector is the selector of RemoveBySelector, RemoveAllBySelector, of
+	// the updates (UpdateID, UpdateAll, UpdateDoc and Restore) and of the
+	// document claimed by a Query.Apply removal
+	Selector interface{}
+
+	// Update is the update document of UpdateID, UpdateAll, UpdateDoc, Restore
+	// and Query.Apply (nil if the Apply update is not a bson.M)
+	Update bson.M
+
+	// Info is the change info of the selector based removals and of the updates
+	Info *mgo.ChangeInfo
+
+	// Force is true when a document of a soft delete model is removed
+	// from database (ForceRemove)
+	Force bool
+}
+
+// Handler executes an operation. Iter.Next handlers return mgo.ErrNotFound
+// when the iterator is exhausted.
+type Handler func(op *Operation) error
+
+// Plugin wraps the next handler of the pipeline. A plugin can run code before
+// and after calling next, or return without calling it to skip the operation.
+type Plugin func(next Handler) Handler
+
+// Use appends plugins to the pipeline of all the operations made with the
+// connection. Plugins run in the passed order, before the model plugins.
+// The operations already started keep running with the previous pipeline.
+func (m *Connection) Use(plugins ...Plugin) {
+	defer mu.Unlock()
+
+	mu.Lock()
+	m.plugins = append(m.plugins, plugins...)
+}
+
+// Use appends plugins to the pipeline of the operations on the named model
+// (see ExistsByName). Model plugins run after the connection ones. The
+// operations already started keep running with the previous pipeline.
+func (r ModelReg) Use(name string, plugins ...Plugin) {
+	defer mu.Unlock()
+
+	mu.Lock()
+	_, ri, err := r.Lookup(name)
+	if err != nil {
+		panic(err)
+	}
+
+	ri.Plugins = append(ri.Plugins, plugins...)
+}
+
+// runPipeline executes core wrapped by the document hooks, the model plugins
+// and the connection plugins
+func runPipeline(conn *Connection, op *Operation, core Handler) error {
+	h := hooksPlugin(core)
+
+	plugins := pipelinePlugins(conn, op.Model)
+	for i := len(plugins) - 1; i >= 0; i-- {
+		h = plugins[i](h)
+	}
+
+	return h(op)
+}
+
+// pipelinePlugins returns a copy of the connection plugins followed by the
+// plugins of the named model, taken under the lock held by Use
+func pipelinePlugins(conn *Connection, model string) []Plugin {
+	var plugins []Plugin
+
+	defer mu.RUnlock()
+
+	mu.RLock()
+	if conn != nil {
+		plugins = append(plugins, conn.plugins...)
+	}
+
+	if _, ri, ok := conn.Models().ExistsByName(model); ok {
+		plugins = append(plugins, ri.Plugins...)
+	}
+
+	return plugins
+}
+
+// hooksPlugin runs the document hooks around the operation
+func hooksPlugin(next Handler) Handler {
+	return func(op *Operation) error {
+		if op.Doc == nil {
+			return next(op)
+		}
+
+		var err error
+		switch op.Hook.Op {
+		case OpSave:
+			err = runValidate(op.Doc, op.Hook)
+			if err != nil {
+				return err
+			}
+
+			err = runBeforeSave(op.Doc, op.Hook)
+			if err != nil {
+				return err
+			}
+
+			err = next(op)
+			if err != nil {
+				return err
+			}
+
+			return runAfterSave(op.Doc, op.Hook)
+		case OpRemove:
+			err = runBeforeDelete(op.Doc, op.Hook)
+			if err != nil {
+				return err
+			}
+
+			err = next(op)
+			if err != nil {
+				return err
+			}
+
+			return runAfterDelete(op.Doc, op.Hook)
+		case OpFind:
+			err = next(op)
+			if err != nil {
+				return err
+			}
+
+			return runAfterFind(op.Doc, op.Hook)
+		}
+
+		return next(op)
+	}
+}
+
+// operation returns the Operation on doc made with col
+func (c *Collection) operation(col *mgo.Collection, kind HookOp, doc Document) *Operation {
+	op := &Operation{
+		Hook: c.hookContext(col, kind),
+		Doc:  doc,
+	}
+
+	if doc != nil {
+		op.Model, _ = doc.GetMe()
+	} else {
+		op.Model = c.modelName()
+	}
+
+	return op
+}
+
+// modelName returns the name of the model stored in the collection,
+// empty if there is none or more than one
+func (c *Collection) modelName() string {
+	name := ""
+	for k, v := range c.Connection.Models() {
+		if v.Collection != c.Name {
+			continue
+		}
+		if name != "" {
+			return ""
+		}
+		name = k
+	}
+
+	return name
+}
@@ -0,0 +1,148 @@
+package mogo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type Tenanted struct {
+	DocumentModel `bson:",inline" coll:"tenanted"`
+	Tenant        string
+	Name          string
+}
+
+// tracePlugin appends its name to trace before and after the next handler
+func tracePlugin(name string, trace *[]string) Plugin {
+	return func(next Handler) Handler {
+		return func(op *Operation) error {
+			*trace = append(*trace, name+">")
+			err := next(op)
+			*trace = append(*trace, "<"+name)
+			return err
+		}
+	}
+}
+
+// tenantPlugin sets the tenant on saved documents and filters queries and removals
+func tenantPlugin(tenant string) Plugin {
+	return func(next Handler) Handler {
+		return func(op *Operation) error {
+			if op.Model != "Tenanted" {
+				return next(op)
+			}
+
+			switch {
+			case op.Query != nil:
+				op.Query.Find(bson.M{"$and": []interface{}{op.Query.Query, bson.M{"tenant": tenant}}})
+			case op.Selector != nil:
+				op.Selector = bson.M{"$and": []interface{}{op.Selector, bson.M{"tenant": tenant}}}
+			case op.Hook.Op == OpSave:
+				op.Doc.(*Tenanted).Tenant = tenant
+			}
+
+			return next(op)
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	Convey("Plugins should wrap the operation in order", t, func() {
+		ModelRegistry.Register(Tenanted{})
+
+		trace := []string{}
+		conn := &Connection{}
+		conn.Use(tracePlugin("a", &trace), tracePlugin("b", &trace))
+		ModelRegistry.Use("Tenanted", tracePlugin("m", &trace))
+		defer func() { ModelRegistry.Model("Tenanted").Plugins = nil }()
+
+		op := &Operation{Hook: &HookContext{Op: OpRemove}, Model: "Tenanted"}
+		err := runPipeline(conn, op, func(op *Operation) error {
+			trace = append(trace, "core")
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(trace, ShouldResemble, []string{"a>", "b>", "m>", "core", "<m", "<b", "<a"})
+
+		So(func() { ModelRegistry.Use("Unknown", tracePlugin("x", &trace)) }, ShouldPanic)
+	})
+
+	Convey("Plugins can be added while operations run", t, func() {
+		ModelRegistry.Register(Tenanted{})
+		defer func() { ModelRegistry.Model("Tenanted").Plugins = nil }()
+
+		conn := &Connection{}
+		nop := func(next Handler) Handler { return next }
+		done := make(chan bool)
+		go func() {
+			for i := 0; i < 100; i++ {
+				conn.Use(nop)
+				ModelRegistry.Use("Tenanted", nop)
+			}
+			close(done)
+		}()
+
+		for i := 0; i < 100; i++ {
+			op := &Operation{Hook: &HookContext{Op: OpRemove}, Model: "Tenanted"}
+			So(runPipeline(conn, op, func(op *Operation) error { return nil }), ShouldBeNil)
+		}
+		<-done
+	})
+}
+
+func TestPlugins(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	ModelRegistry.Register(Tenanted{})
+
+	Convey("Plugins", t, func() {
+		conn.plugins = nil
+		defer func() { conn.plugins = nil }()
+
+		DBConn.Collection("tenanted").C().RemoveAll(nil)
+
+		other := NewDoc(Tenanted{Tenant: "other", Name: "x"}).(*Tenanted)
+		So(Save(other), ShouldBeNil)
+
+		conn.Use(tenantPlugin("acme"))
+
+		Convey("should mutate the saved document", func() {
+			d := NewDoc(Tenanted{Name: "x"}).(*Tenanted)
+			So(Save(d), ShouldBeNil)
+			So(d.Tenant, ShouldEqual, "acme")
+		})
+
+		Convey("should mutate queries and selectors", func() {
+			d := NewDoc(Tenanted{Name: "x"}).(*Tenanted)
+			So(Save(d), ShouldBeNil)
+
+			f := NewDoc(Tenanted{}).(*Tenanted)
+			So(Find(f, bson.M{"name": "x"}).One(f), ShouldBeNil)
+			So(f.ID, ShouldEqual, d.ID)
+
+			So(FindID(f, other.ID).One(f), ShouldEqual, mgo.ErrNotFound)
+
+			So(RemoveBySelector(f, bson.M{"_id": other.ID}), ShouldEqual, mgo.ErrNotFound)
+			So(RemoveBySelector(f, bson.M{"_id": d.ID}), ShouldBeNil)
+		})
+
+		Convey("should short-circuit the operation", func() {
+			conn.Use(func(next Handler) Handler {
+				return func(op *Operation) error {
+					if op.Hook.Op == OpRemove {
+						return errors.New("read only")
+					}
+					return next(op)
+				}
+			})
+
+			So(other.Remove(), ShouldNotBeNil)
+			n, _ := other.GetColl().C().FindId(other.ID).Count()
+			So(n, ShouldEqual, 1)
+		})
+	})
+}
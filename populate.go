@@ -0,0 +1,347 @@
+package mogo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// PopulateMaxDepth is the maximum number of levels of a populate path (see PopulateAll)
+var PopulateMaxDepth = 5
+
+// Populated contains the referenced documents loaded by PopulateAll for a ref field
+type Populated struct {
+	// Docs are the loaded documents keyed by RefField.ID
+	Docs map[bson.ObjectId]Document
+
+	// Dangling are the referenced ids for which no document was found
+	Dangling []bson.ObjectId
+
+	// Cycles are the referenced ids not followed because they refer to
+	// a document already met in the populate path
+	Cycles []bson.ObjectId
+}
+
+// populateLevel contains the documents of a populate path level and, for each
+// of them, the ids of the documents met in the path to reach it
+type populateLevel struct {
+	items     []reflect.Value
+	ancestors []map[bson.ObjectId]bool
+}
+
+// PopulateAll loads the documents referenced by the passed ref fields of docs,
+// which can be a document (pointer) or a slice of documents ([]T or []*T) of the
+// same model. A single query (using $in) is made for each field, whatever the number
+// of documents. If the ref field has a companion field (populate tag) it is filled
+// with the loaded documents, keeping the order of the references, i.e.:
+//
+//	Friends     RefFieldSlice `ref:"Macao"`
+//	FriendsDocs []*Macao      `bson:"-" populate:"Friends"`
+//
+// Fields can be dotted paths (i.e. BestFriend.Friends) to populate the references
+// of the populated documents, up to PopulateMaxDepth levels. References to documents
+// already met in the path are not followed (see Populated.Cycles).
+// The loaded documents and the dangling references are returned keyed by field path.
+func PopulateAll(docs interface{}, fields ...string) (map[string]*Populated, error) {
+	items, err := documentValues(docs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*Populated, len(fields))
+	root := &populateLevel{items: items, ancestors: make([]map[bson.ObjectId]bool, len(items))}
+	levels := map[string]*populateLevel{"": root}
+	fills := []func() error{}
+
+	for _, f := range fields {
+		names := strings.Split(f, ".")
+		if len(names) > PopulateMaxDepth {
+			return result, fmt.Errorf("populate path %s exceeds the maximum depth (%d)", f, PopulateMaxDepth)
+		}
+
+		prefix := ""
+		for _, name := range names {
+			path := name
+			if prefix != "" {
+				path = prefix + "." + name
+			}
+
+			if _, ok := levels[path]; !ok {
+				p, next, fill, err := populateField(levels[prefix], path, name)
+				if err != nil {
+					return result, err
+				}
+
+				result[path] = p
+				levels[path] = next
+				fills = append(fills, fill)
+			}
+			prefix = path
+		}
+	}
+
+	// Deeper levels first, so documents copied in companion fields are complete
+	for i := len(fills) - 1; i >= 0; i-- {
+		if err := fills[i](); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// populateField loads the documents referenced by the field f of all items in level,
+// returning the next level and the func to fill the companion fields
+func populateField(level *populateLevel, path string, f string) (*Populated, *populateLevel, func() error, error) {
+	p := &Populated{
+		Docs: make(map[bson.ObjectId]Document),
+	}
+	next := &populateLevel{}
+	nop := func() error { return nil }
+
+	if len(level.items) == 0 {
+		return p, next, nop, nil
+	}
+
+	// the items are documents of the same model, bound to the same connection
+	first := level.items[0].Interface()
+	reg := registryOf(first)
+	n, ri, ok := reg.Exists(first)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("the document model is not registered (type: %s)", level.items[0].Type().Elem().Name())
+	}
+
+	r, ok := ri.Refs[f]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("%s is not a ref field (model: %s, path: %s)", f, n, path)
+	}
+
+	if !r.Exists {
+		return nil, nil, nil, fmt.Errorf("the referenced model %s is not registered (path: %s)", r.Ref, path)
+	}
+
+	refs := make([][]bson.ObjectId, len(level.items))
+	ids := []bson.ObjectId{}
+	seen := make(map[bson.ObjectId]bool)
+	cycles := make(map[bson.ObjectId]bool)
+
+	for i, item := range level.items {
+		self := item.Interface().(Document).GetID()
+		for _, id := range refIDs(item.Elem().Field(r.Idx)) {
+			if id == self || level.ancestors[i][id] {
+				if !cycles[id] {
+					cycles[id] = true
+					p.Cycles = append(p.Cycles, id)
+				}
+				continue
+			}
+
+			refs[i] = append(refs[i], id)
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	if len(ids) > 0 {
+		t := reg.New(r.Ref).(Document)
+		bindLike(t, first)
+		loaded := reflect.New(reflect.SliceOf(reflect.PtrTo(reg.TypeOf(r.Ref))))
+
+		err := Find(t, bson.M{"_id": bson.M{"$in": ids}}).All(loaded.Interface())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		for i := 0; i < loaded.Elem().Len(); i++ {
+			d := loaded.Elem().Index(i).Interface().(Document)
+			p.Docs[d.GetID()] = d
+		}
+	}
+
+	// The next level contains the loaded documents, each one with the ancestors
+	// of all the documents referencing it
+	pos := make(map[bson.ObjectId]int)
+	for _, id := range ids {
+		d, ok := p.Docs[id]
+		if !ok {
+			p.Dangling = append(p.Dangling, id)
+			continue
+		}
+
+		pos[id] = len(next.items)
+		next.items = append(next.items, reflect.ValueOf(d))
+		next.ancestors = append(next.ancestors, make(map[bson.ObjectId]bool))
+	}
+
+	for i, item := range level.items {
+		self := item.Interface().(Document).GetID()
+		for _, id := range refs[i] {
+			j, ok := pos[id]
+			if !ok {
+				continue
+			}
+
+			next.ancestors[j][self] = true
+			for a := range level.ancestors[i] {
+				next.ancestors[j][a] = true
+			}
+		}
+	}
+
+	if r.Target == "" {
+		return p, next, nop, nil
+	}
+
+	fill := func() error {
+		for i, item := range level.items {
+			err := setPopulated(item.Elem().FieldByName(r.Target), refs[i], p.Docs, reg.TypeOf(r.Ref))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return p, next, fill, nil
+}
+
+// setPopulated fills the companion field fv with the documents referenced by ids
+func setPopulated(fv reflect.Value, ids []bson.ObjectId, docs map[bson.ObjectId]Document, t reflect.Type) error {
+	ft := fv.Type()
+	if ft.Kind() == reflect.Slice {
+		ft = ft.Elem()
+	}
+	byValue := ft.Kind() != reflect.Ptr
+	if !byValue {
+		ft = ft.Elem()
+	}
+
+	if ft != t {
+		return fmt.Errorf("the populate field type %s does not match the referenced model %s", fv.Type(), t.Name())
+	}
+
+	if fv.Kind() == reflect.Slice {
+		sv := reflect.MakeSlice(fv.Type(), 0, len(ids))
+		for _, id := range ids {
+			if d, ok := docs[id]; ok {
+				sv = reflect.Append(sv, documentValue(d, byValue))
+			}
+		}
+		fv.Set(sv)
+
+		if byValue {
+			for i := 0; i < sv.Len(); i++ {
+				restoreMe(sv.Index(i).Addr())
+			}
+		}
+		return nil
+	}
+
+	if len(ids) == 0 || docs[ids[0]] == nil {
+		fv.Set(reflect.Zero(fv.Type()))
+		return nil
+	}
+
+	fv.Set(documentValue(docs[ids[0]], byValue))
+	if byValue {
+		restoreMe(fv.Addr())
+	}
+
+	return nil
+}
+
+func documentValue(d Document, byValue bool) reflect.Value {
+	v := reflect.ValueOf(d)
+	if byValue {
+		return v.Elem()
+	}
+
+	return v
+}
+
+// restoreMe makes the me field (and the diff tracker) of the document pointed
+// by v to point to v
+func restoreMe(v reflect.Value) {
+	d := v.Interface().(Document)
+	iname, _ := d.GetMe()
+	d.SetMe(iname, d)
+
+	tracker, ok := d.(Trackable)
+	if !ok {
+		return
+	}
+
+	// The copy shares the tracker of the document it was made from
+	if setter, ok := d.(DiffTrackerSetter); ok {
+		setter.SetDiffTracker(tracker.GetDiffTracker().Copy(d))
+		return
+	}
+
+	// Without a setter the shared tracker follows the last copy. The original
+	// documents are discarded, but not the copies of a document populating
+	// many values
+	tracker.GetDiffTracker().current = d
+}
+
+// refIDs returns the ids stored in a RefField, RefFieldSlice or []RefField field
+func refIDs(fv reflect.Value) []bson.ObjectId {
+	var ids []bson.ObjectId
+
+	rt := reflect.TypeOf(RefField{})
+	add := func(v reflect.Value) {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return
+			}
+			v = v.Elem()
+		}
+
+		if r := v.Convert(rt).Interface().(RefField); r.ID.Valid() {
+			ids = append(ids, r.ID)
+		}
+	}
+
+	if fv.Kind() == reflect.Slice {
+		for i := 0; i < fv.Len(); i++ {
+			add(fv.Index(i))
+		}
+		return ids
+	}
+
+	add(fv)
+	return ids
+}
+
+// documentValues returns the pointers to the documents passed as a single
+// document pointer or as a slice of documents
+func documentValues(docs interface{}) ([]reflect.Value, error) {
+	v := reflect.ValueOf(docs)
+	if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice {
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Slice:
+		items := make([]reflect.Value, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e := v.Index(i)
+			if e.Kind() != reflect.Ptr {
+				e = e.Addr()
+			} else if e.IsNil() {
+				continue
+			}
+			items = append(items, e)
+		}
+		return items, nil
+	case reflect.Ptr:
+		if v.Elem().Kind() == reflect.Struct {
+			return []reflect.Value{v}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("docs argument must be a document pointer or a slice of documents (passed %T)", docs)
+}
@@ -0,0 +1,159 @@
+package mogo
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type Parrot struct {
+	DocumentModel `bson:",inline" coll:"parrots"`
+	Name          string
+	Friends       RefFieldSlice `ref:"Canary"`
+	BestFriend    RefField      `ref:"Canary"`
+	FriendsDocs   []*Canary     `bson:"-" populate:"Friends"`
+	BestFriendDoc Canary        `bson:"-" populate:"BestFriend"`
+}
+
+type Canary struct {
+	DocumentModel `bson:",inline" coll:"canaries"`
+	Name          string
+}
+
+type Owl struct {
+	DocumentModel `bson:",inline" coll:"owls"`
+	Name          string
+	BestFriend    RefField      `ref:"Owl"`
+	Friends       RefFieldSlice `ref:"Owl"`
+	BestFriendDoc *Owl          `bson:"-" populate:"BestFriend"`
+	FriendsDocs   []Owl         `bson:"-" populate:"Friends"`
+}
+
+func TestPopulateAll(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	ModelRegistry.Register(Parrot{}, Canary{})
+
+	Convey("PopulateAll", t, func() {
+		canaries := make([]*Canary, 4)
+		for i := range canaries {
+			canaries[i] = NewDoc(Canary{Name: string(rune('a' + i))}).(*Canary)
+			So(Save(canaries[i]), ShouldBeNil)
+		}
+
+		dangling := bson.NewObjectId()
+		p1 := NewDoc(Parrot{
+			Name:       "p1",
+			Friends:    RefFieldSlice{{ID: canaries[2].ID}, {ID: dangling}, {ID: canaries[0].ID}},
+			BestFriend: RefField{ID: canaries[1].ID},
+		}).(*Parrot)
+		So(Save(p1), ShouldBeNil)
+
+		p2 := NewDoc(Parrot{
+			Name:       "p2",
+			Friends:    RefFieldSlice{{ID: canaries[3].ID}},
+			BestFriend: RefField{ID: canaries[0].ID},
+		}).(*Parrot)
+		So(Save(p2), ShouldBeNil)
+
+		Convey("should fill the companion fields of all documents keeping the order", func() {
+			parrots := []*Parrot{}
+			err := p1.Find(nil).Sort("name").All(&parrots)
+			So(err, ShouldBeNil)
+
+			res, err := PopulateAll(&parrots, "Friends", "BestFriend")
+			So(err, ShouldBeNil)
+
+			So(len(parrots[0].FriendsDocs), ShouldEqual, 2)
+			So(parrots[0].FriendsDocs[0].Name, ShouldEqual, "c")
+			So(parrots[0].FriendsDocs[1].Name, ShouldEqual, "a")
+			So(parrots[0].BestFriendDoc.Name, ShouldEqual, "b")
+			So(parrots[0].BestFriendDoc.AsDocument(), ShouldEqual, &parrots[0].BestFriendDoc)
+			So(parrots[1].FriendsDocs[0].Name, ShouldEqual, "d")
+			So(parrots[1].BestFriendDoc.Name, ShouldEqual, "a")
+
+			So(len(res["Friends"].Docs), ShouldEqual, 3)
+			So(res["Friends"].Dangling, ShouldResemble, []bson.ObjectId{dangling})
+			So(res["BestFriend"].Docs[canaries[1].ID].(*Canary).Name, ShouldEqual, "b")
+			So(len(res["BestFriend"].Dangling), ShouldEqual, 0)
+		})
+
+		Convey("should populate a single document", func() {
+			res, err := p2.PopulateAll("Friends")
+			So(err, ShouldBeNil)
+			So(len(res["Friends"].Docs), ShouldEqual, 1)
+			So(p2.FriendsDocs[0].Name, ShouldEqual, "d")
+			So(p2.FriendsDocs[0].IsNew(), ShouldBeFalse)
+		})
+
+		Convey("should return an error for fields which are not ref fields", func() {
+			_, err := PopulateAll(p1, "Name")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should return a populate query for the package level Populate", func() {
+			results := []Canary{}
+			err := Populate(p1, bson.M{"name": "c"}, "Friends").All(&results)
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 1)
+		})
+
+		Reset(func() {
+			conn.Session.DB("mogotest").DropDatabase()
+		})
+	})
+}
+
+func TestPopulateAllNested(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	ModelRegistry.Register(Owl{})
+
+	Convey("PopulateAll with nested paths", t, func() {
+		a := NewDoc(Owl{Name: "a"}).(*Owl)
+		b := NewDoc(Owl{Name: "b"}).(*Owl)
+		c := NewDoc(Owl{Name: "c"}).(*Owl)
+		a.AsNew()
+		b.AsNew()
+		c.AsNew()
+
+		a.BestFriend = RefField{ID: b.ID}
+		b.BestFriend = RefField{ID: c.ID}
+		c.BestFriend = RefField{ID: a.ID}
+		a.Friends = RefFieldSlice{{ID: b.ID}}
+		b.Friends = RefFieldSlice{{ID: c.ID}}
+		So(Save(a), ShouldBeNil)
+		So(Save(b), ShouldBeNil)
+		So(Save(c), ShouldBeNil)
+
+		Convey("should populate the references of the populated documents", func() {
+			res, err := a.PopulateAll("Friends.Friends")
+			So(err, ShouldBeNil)
+			So(len(res["Friends"].Docs), ShouldEqual, 1)
+			So(len(res["Friends.Friends"].Docs), ShouldEqual, 1)
+			So(a.FriendsDocs[0].Name, ShouldEqual, "b")
+			So(a.FriendsDocs[0].FriendsDocs[0].Name, ShouldEqual, "c")
+		})
+
+		Convey("should not follow the references to documents already met in the path", func() {
+			res, err := a.PopulateAll("BestFriend.BestFriend.BestFriend")
+			So(err, ShouldBeNil)
+			So(a.BestFriendDoc.Name, ShouldEqual, "b")
+			So(a.BestFriendDoc.BestFriendDoc.Name, ShouldEqual, "c")
+			So(a.BestFriendDoc.BestFriendDoc.BestFriendDoc, ShouldBeNil)
+			So(res["BestFriend.BestFriend.BestFriend"].Cycles, ShouldResemble, []bson.ObjectId{a.ID})
+		})
+
+		Convey("should return an error if the path is too deep", func() {
+			_, err := a.PopulateAll("BestFriend.BestFriend.BestFriend.BestFriend.BestFriend.BestFriend")
+			So(err, ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			conn.Session.DB("mogotest").DropDatabase()
+		})
+	})
+}
@@ -1,9 +1,13 @@
 package mogo
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"math"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
@@ -22,8 +26,35 @@ type Query struct {
 	// Query is the query used to build the mgo.Query. In case of populate query its type is bson.M
 	// with $and operator to merge with the target id(s) of the target Model
 	Query interface{}
+
+	// options (sort, projection, ...) applied to MgoQ, they are applied again when
+	// MgoQ is rebuilt by Find
+	options    []func(*mgo.Query) *mgo.Query
+	projection bson.M
+
+	// coll is the Collection which created the query (hooks context and plugins)
+	coll *Collection
+
+	// deleted selects the soft deleted documents returned by the query
+	deleted deletedMode
+
+	// sort, skip and limit options, also applied to the With aggregation
+	sort        []string
+	skip, limit int
+
+	// with are the ref fields joined by $lookup (see With)
+	with []string
 }
 
+// deletedMode selects the soft deleted documents returned by a query
+type deletedMode int
+
+const (
+	excludeDeleted deletedMode = iota
+	withDeleted
+	onlyDeleted
+)
+
 // Iter is the mgo.Iter wrapper
 type Iter struct {
 	MgoQ    *mgo.Query
@@ -32,6 +63,22 @@ type Iter struct {
 	Err     error
 
 	Pagination *Paginate
+
+	// The Query which created the iterator
+	query *Query
+
+	// The Aggregation which created the iterator
+	pipe *Aggregation
+
+	// joins are the ref fields joined to the loaded documents (see Query.With)
+	joins []join
+}
+
+// loader is the source of the documents loaded by an Iter
+type loader interface {
+	operation(doc Document) *Operation
+	connection() *Connection
+	bindingFor(doc Document) docBinding
 }
 
 // Paginate ...
@@ -41,6 +88,16 @@ type Paginate struct {
 	N      int `json:"items"`  // Items per pages
 	T      int `json:"total"`  // Total records in query
 	OnPage int `json:"onPage"` // Records in current page
+
+	Estimated bool   `json:"estimated"`        // T is the estimated count of the collection documents
+	Key       string `json:"key,omitempty"`    // Sort key used for keyset pagination
+	Cursor    string `json:"cursor,omitempty"` // Token of the last loaded document (keyset pagination)
+}
+
+// cursorToken is the content of the keyset pagination cursor
+type cursorToken struct {
+	Key interface{}   `bson:"k"`
+	ID  bson.ObjectId `bson:"id"`
 }
 
 // PopulateInfo ...
@@ -69,7 +126,7 @@ func (q *Query) Find(query interface{}) *Query {
 		if _, ok := query.(bson.M); ok {
 			refactor := q.Query.(bson.M)
 			refactor["$and"] = append(refactor["$and"].([]bson.M), query.(bson.M))
-			q.MgoQ = q.MgoC.Find(q.Query)
+			q.rebuild()
 			return q
 		}
 
@@ -79,41 +136,215 @@ func (q *Query) Find(query interface{}) *Query {
 	// Add case: query is not of populate type make an $and or replace existing
 	//  replace existings for now (mae an and doesn't make sense at now)
 	q.Query = query
-	q.MgoQ = q.MgoC.Find(q.Query)
+	q.rebuild()
 
 	return q
 }
 
-// All is a wrapper around mgo.Query.All (TODO: hooks should be triggered)
+// rebuild makes a new mgo.Query using the Query field, and applies all options
+// previously set on the Query object
+func (q *Query) rebuild() {
+	q.MgoQ = q.MgoC.Find(q.filter())
+	for _, opt := range q.options {
+		q.MgoQ = opt(q.MgoQ)
+	}
+}
+
+// filter returns the query filter, excluding (or selecting) the soft deleted
+// documents of soft delete models
+func (q *Query) filter() interface{} {
+	return softFilter(q.connection().Models(), q.MgoC.Name, q.Query, q.deleted)
+}
+
+// WithDeleted makes the query return also the soft deleted documents
+func (q *Query) WithDeleted() *Query {
+	q.deleted = withDeleted
+	q.rebuild()
+
+	return q
+}
+
+// OnlyDeleted makes the query return only the soft deleted documents
+func (q *Query) OnlyDeleted() *Query {
+	q.deleted = onlyDeleted
+	q.rebuild()
+
+	return q
+}
+
+// apply applies the option to MgoQ and stores it for next rebuild
+func (q *Query) apply(opt func(*mgo.Query) *mgo.Query) *Query {
+	q.options = append(q.options, opt)
+	q.MgoQ = opt(q.MgoQ)
+	return q
+}
+
+// All is a wrapper around mgo.Query.All. The result argument must be the address
+// of a slice of registered documents ([]T or []*T). Each document is created with
+// NewDoc and loaded using the Iter.Next method, so AfterFindHook is executed and
+// the NewTracker interface is updated as for a single document.
+// Note: Trackable documents should use []*T since the diff tracker refers to the
+// loaded pointer.
 func (q *Query) All(result interface{}) error {
-	return q.MgoQ.All(result)
+	iter := q.Iter()
+	iter.fill(result)
+
+	err := iter.Close()
+	if iter.Err != nil {
+		return iter.Err
+	}
+
+	return err
 }
 
-// Iter is a wrapper around mgo.Query.Iter
+// Iter is a wrapper around mgo.Query.Iter. If the query joins ref fields
+// (see With) the aggregation is started by the first call to Next.
 func (q *Query) Iter() *Iter {
 	i := &Iter{
 		MgoQ:       q.MgoQ,
-		MgoI:       q.MgoQ.Iter(),
 		Pagination: q.Pagination,
 		Timeout:    false,
 		Err:        nil,
+		query:      q,
+	}
+
+	if len(q.with) == 0 {
+		i.MgoI = q.MgoQ.Iter()
 	}
 
 	return i
 }
 
+// operation returns the Operation loading doc with the query
+func (q *Query) operation(doc Document) *Operation {
+	op := &Operation{
+		Hook:  &HookContext{Collection: q.MgoC, Op: OpFind},
+		Doc:   doc,
+		Query: q,
+	}
+	op.Model, _ = doc.GetMe()
+
+	if q.coll != nil {
+		op.Hook.Context = q.coll.Context
+	}
+
+	return op
+}
+
+// bindingFor returns the binding of the document d loaded by the query, that
+// is the connection, database and collection of the query (the collection
+// only if it is not the model one). It is the binding of d if the query
+// collection is unknown.
+func (q *Query) bindingFor(d Document) docBinding {
+	if q == nil || q.coll == nil {
+		return bindingOf(d)
+	}
+
+	b := docBinding{conn: q.coll.Connection, db: q.coll.Database}
+	iname, _ := d.GetMe()
+	if _, ri, ok := q.coll.Connection.Models().ExistsByName(iname); !ok || ri.Collection != q.coll.Name {
+		b.coll = q.coll.Name
+	}
+
+	return b
+}
+
+// connection returns the Connection of the query, nil if unknown
+func (q *Query) connection() *Connection {
+	if q == nil || q.coll == nil {
+		return nil
+	}
+
+	return q.coll.Connection
+}
+
 // Limit is a wrapper around mgo.Query.Limit
 func (q *Query) Limit(n int) *Query {
-	q.MgoQ = q.MgoQ.Limit(n)
-	return q
+	q.limit = n
+	return q.apply(func(mq *mgo.Query) *mgo.Query {
+		return mq.Limit(n)
+	})
 }
 
 // Skip is a wrapper around mgo.Query.Skip
 func (q *Query) Skip(n int) *Query {
-	q.MgoQ = q.MgoQ.Skip(n)
+	q.skip = n
+	return q.apply(func(mq *mgo.Query) *mgo.Query {
+		return mq.Skip(n)
+	})
+}
+
+// Sort is a wrapper around mgo.Query.Sort. Field names are the bson ones,
+// prefixed by - for descending order.
+func (q *Query) Sort(fields ...string) *Query {
+	q.sort = fields
+	return q.apply(func(mq *mgo.Query) *mgo.Query {
+		return mq.Sort(fields...)
+	})
+}
+
+// Select sets the projection of the query to include only the passed
+// fields (bson names). See mgo.Query.Select.
+func (q *Query) Select(fields ...string) *Query {
+	return q.project(1, fields)
+}
+
+// Omit sets the projection of the query to exclude the passed fields (bson names).
+// See mgo.Query.Select.
+func (q *Query) Omit(fields ...string) *Query {
+	return q.project(0, fields)
+}
+
+func (q *Query) project(v int, fields []string) *Query {
+	if q.projection == nil {
+		q.projection = bson.M{}
+		q.apply(func(mq *mgo.Query) *mgo.Query {
+			return mq.Select(q.projection)
+		})
+	}
+
+	for _, f := range fields {
+		q.projection[f] = v
+	}
+
 	return q
 }
 
+// Hint is a wrapper around mgo.Query.Hint
+func (q *Query) Hint(indexKey ...string) *Query {
+	return q.apply(func(mq *mgo.Query) *mgo.Query {
+		return mq.Hint(indexKey...)
+	})
+}
+
+// Batch is a wrapper around mgo.Query.Batch
+func (q *Query) Batch(n int) *Query {
+	return q.apply(func(mq *mgo.Query) *mgo.Query {
+		return mq.Batch(n)
+	})
+}
+
+// Prefetch is a wrapper around mgo.Query.Prefetch
+func (q *Query) Prefetch(p float64) *Query {
+	return q.apply(func(mq *mgo.Query) *mgo.Query {
+		return mq.Prefetch(p)
+	})
+}
+
+// SetMaxTime is a wrapper around mgo.Query.SetMaxTime
+func (q *Query) SetMaxTime(d time.Duration) *Query {
+	return q.apply(func(mq *mgo.Query) *mgo.Query {
+		return mq.SetMaxTime(d)
+	})
+}
+
+// Comment is a wrapper around mgo.Query.Comment
+func (q *Query) Comment(comment string) *Query {
+	return q.apply(func(mq *mgo.Query) *mgo.Query {
+		return mq.Comment(comment)
+	})
+}
+
 // Paginate prepares the Query to allow pagination
 func (q *Query) Paginate(n int) *Query {
 	q.Pagination = &Paginate{
@@ -127,12 +358,51 @@ func (q *Query) Paginate(n int) *Query {
 	return q
 }
 
+// PaginateByKey prepares the Query to allow keyset (cursor based) pagination.
+// Pages are sorted by the passed key (bson name, prefixed by - for descending order)
+// and by _id, and each page starts after the last document of the previous one,
+// so no documents are counted or skipped. The key should be indexed, an empty key
+// means _id.
+func (q *Query) PaginateByKey(n int, key string) *Query {
+	if key == "" {
+		key = "_id"
+	}
+
+	q.Pagination = &Paginate{
+		N:   n,
+		Key: key,
+	}
+
+	return q
+}
+
+// Cursor sets the cursor token (see Paginate.Cursor) after which the
+// keyset pagination starts
+func (q *Query) Cursor(token string) *Query {
+	if q.Pagination != nil {
+		q.Pagination.Cursor = token
+	}
+
+	return q
+}
+
+// EstimatedCount makes the pagination use the count of all documents in
+// collection instead of counting the query results. It should be used for
+// queries without filter or when an approximation of the pages is acceptable.
+func (q *Query) EstimatedCount() *Query {
+	if q.Pagination != nil {
+		q.Pagination.Estimated = true
+	}
+
+	return q
+}
+
 // One is a wrapper around mgo.Query.One
 func (q *Query) One(result interface{}) error {
 	var iname string
 	var err error
 	var ok bool
-	var d Model
+	var d Document
 
 	if d, ok = result.(Document); ok {
 		iname, _ = d.GetMe()
@@ -140,26 +410,188 @@ func (q *Query) One(result interface{}) error {
 		panic("result is not a mogo document")
 	}
 
-	if err = q.MgoQ.One(result); err != nil {
-		d.SetMe(iname, result)
+	if len(q.with) > 0 {
+		return q.oneWith(d)
+	}
+
+	op := q.operation(d)
+	b := q.bindingFor(d)
+	err = runPipeline(q.connection(), op, func(op *Operation) error {
+		err := op.Query.MgoQ.One(op.Doc)
+		// Restoring the iname Document field
+		op.Doc.SetMe(iname, op.Doc)
+		b.apply(op.Doc)
+
+		return err
+	})
+	if err != nil {
 		return err
 	}
-	// Restoring the iname Document field
-	d.SetMe(iname, result)
 
-	if hook, ok := d.(AfterFindHook); ok {
-		err = hook.AfterFind()
+	loaded(op.Doc)
+	return nil
+}
+
+// Apply is a wrapper around mgo.Query.Apply (findAndModify): it updates or
+// replaces the first document matching the query (see Sort) and loads the
+// original document, or the new one if change.ReturnNew is true, in result.
+// The operation goes through the plugin pipeline as an update (OpUpdate), the
+// loaded document runs AfterFindHook and updates the NewTracker interface, the
+// change info is stored with SetCInfo.
+//
+// As UpdateDoc does, the update sets the modified time and increments the
+// version of versioned models (a replacement document is stamped itself), the
+// field names are not resolved. Upserting with change.ReturnNew false doesn't
+// load result when the document is inserted, use the returned
+// ChangeInfo.UpsertedId.
+//
+// With change.Remove the first matching document is loaded in result and
+// removed as Collection.Remove does (see applyRemove).
+func (q *Query) Apply(change mgo.Change, result interface{}) (*mgo.ChangeInfo, error) {
+	d, ok := result.(Document)
+	if !ok {
+		panic("result is not a mogo document")
+	}
+
+	if change.Remove {
+		return q.applyRemove(d)
+	}
+
+	iname, _ := d.GetMe()
+	op := q.operation(d)
+	op.Hook.Op = OpUpdate
+	change.Update = stampedChange(d, change.Update, time.Now())
+	op.Update, _ = change.Update.(bson.M)
+
+	b := q.bindingFor(d)
+	err := runPipeline(q.connection(), op, func(op *Operation) error {
+		var err error
+
+		if op.Update != nil {
+			change.Update = op.Update
+		}
+
+		op.Info, err = op.Query.MgoQ.Apply(change, op.Doc)
+		op.Doc.SetMe(iname, op.Doc)
+		b.apply(op.Doc)
+		op.Doc.SetCInfo(op.Info)
 		if err != nil {
 			return err
 		}
+
+		// nothing was loaded
+		if op.Info.UpsertedId != nil && !change.ReturnNew {
+			op.Doc = nil
+			return nil
+		}
+
+		return runAfterFind(op.Doc, op.Hook)
+	})
+	if err != nil || op.Doc == nil {
+		return op.Info, err
 	}
 
-	// We retrieved it, so set new to false
-	if newt, ok := d.(NewTracker); ok {
-		newt.SetIsNew(false)
+	loaded(op.Doc)
+	return op.Info, nil
+}
+
+// applyRemove loads in d the first document matching the query and removes it
+// as Collection.Remove does: the delete hooks, the restrict check, the soft
+// delete and the ondelete policies apply. The loaded document is claimed with a
+// single findAndModify matching its id and the query (and not soft deleted), so
+// that concurrent calls don't remove the same document. If the claim fails
+// because someone else removed it in the meantime the next matching document is
+// loaded. A removed document is new, a soft deleted one is not.
+func (q *Query) applyRemove(d Document) (*mgo.ChangeInfo, error) {
+	iname, _ := d.GetMe()
+	b := q.bindingFor(d)
+	sess := q.MgoC.Database.Session
+
+	var lost []interface{}
+	for {
+		err := q.except(lost).One(d)
+		d.SetMe(iname, d)
+		b.apply(d)
+		if err != nil {
+			return nil, err
+		}
+		loaded(d)
+
+		now := time.Now()
+		soft := isSoftDelete(d)
+		claim := bson.M{"_id": d.GetID()}
+		change := mgo.Change{Remove: !soft}
+		if soft {
+			claim["_deleted"] = bson.M{"$exists": false}
+			change.Update = stampedUpdate(d, bson.M{"$set": bson.M{"_deleted": now}}, now)
+		}
+
+		claimed := true
+		op := q.operation(d)
+		op.Hook.Op = OpRemove
+		op.Selector = and(q.filter(), claim)
+		err = runPipeline(q.connection(), op, func(op *Operation) error {
+			err := checkRestrict(sess, op.Doc, !soft)
+			if err != nil {
+				return err
+			}
+
+			_, err = q.MgoC.Find(op.Selector).Apply(change, nil)
+			if err == mgo.ErrNotFound {
+				claimed = false
+			}
+			if err != nil || soft {
+				return err
+			}
+
+			return applyOnDelete(sess, op.Doc)
+		})
+		if !claimed {
+			lost = append(lost, d.GetID())
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		info := &mgo.ChangeInfo{Removed: 1}
+		if soft {
+			info = &mgo.ChangeInfo{Matched: 1, Updated: 1}
+			if sd, ok := d.(SoftDeleteTracker); ok {
+				sd.SetDeleted(&now)
+			}
+			stamped(d, now)
+		} else if newt, ok := d.(NewTracker); ok {
+			newt.SetIsNew(true)
+		}
+		d.SetCInfo(info)
+
+		return info, nil
 	}
+}
 
-	return nil
+// except returns the query of q excluding the documents with the passed ids,
+// with the options of q
+func (q *Query) except(ids []interface{}) *mgo.Query {
+	if len(ids) == 0 {
+		return q.MgoQ
+	}
+
+	mq := q.MgoC.Find(and(q.filter(), bson.M{"_id": bson.M{"$nin": ids}}))
+	for _, opt := range q.options {
+		mq = opt(mq)
+	}
+
+	return mq
+}
+
+// and returns the query matching both query and cond
+func and(query interface{}, cond bson.M) interface{} {
+	if query == nil {
+		return cond
+	}
+
+	return bson.M{"$and": []interface{}{query, cond}}
 }
 
 // Next is a wrapper around mgo.Iter.Next. It executes AfterFindHook and updates
@@ -168,7 +600,7 @@ func (i *Iter) Next(result interface{}) bool {
 	var iname string
 	var err error
 	var ok bool
-	var d Model
+	var d Document
 
 	if d, ok = result.(Document); ok {
 		iname, _ = d.GetMe()
@@ -176,100 +608,380 @@ func (i *Iter) Next(result interface{}) bool {
 		panic("result is not a mogo document")
 	}
 
-	if ok = i.MgoI.Next(result); !ok {
-		i.Err = i.MgoI.Err()
-		if i.MgoI.Timeout() {
-			i.Timeout = true
+	if i.MgoI == nil {
+		// the error of an aggregation which can not run is already set
+		if i.Err == nil {
+			i.Err = i.lookup(d)
+		}
+		if i.Err != nil {
 			return false
 		}
+	}
 
-		d.SetMe(iname, result)
-		return false
+	op := &Operation{Hook: &HookContext{Op: OpFind}, Doc: d, Model: iname}
+	b := bindingOf(d)
+	if src := i.source(); src != nil {
+		op = src.operation(d)
+		b = src.bindingFor(d)
 	}
 
-	d.SetMe(iname, result)
-	if hook, ok := d.(AfterFindHook); ok {
-		err = hook.AfterFind()
-		if err != nil {
-			i.Err = err
-			return false
+	err = runPipeline(i.connection(), op, func(op *Operation) error {
+		if len(i.joins) > 0 {
+			return i.nextJoined(op, iname, b)
 		}
+
+		if !i.MgoI.Next(op.Doc) {
+			if i.MgoI.Timeout() {
+				i.Timeout = true
+				return mgo.ErrNotFound
+			}
+
+			op.Doc.SetMe(iname, op.Doc)
+			b.apply(op.Doc)
+			if err := i.MgoI.Err(); err != nil {
+				return err
+			}
+			return mgo.ErrNotFound
+		}
+
+		op.Doc.SetMe(iname, op.Doc)
+		b.apply(op.Doc)
+		return nil
+	})
+
+	if err == mgo.ErrNotFound {
+		i.Err = i.MgoI.Err()
+		return false
 	}
 
+	if err != nil {
+		i.Err = err
+		return false
+	}
+
+	loaded(op.Doc)
+	return true
+}
+
+// source returns the Query or the Aggregation which created the iterator,
+// nil if unknown
+func (i *Iter) source() loader {
+	if i.pipe != nil {
+		return i.pipe
+	}
+	if i.query != nil {
+		return i.query
+	}
+
+	return nil
+}
+
+// connection returns the Connection of the iterator, nil if unknown
+func (i *Iter) connection() *Connection {
+	if src := i.source(); src != nil {
+		return src.connection()
+	}
+
+	return nil
+}
+
+// loaded updates the NewTracker and Trackable interfaces of a document
+// retrieved from the database
+func loaded(d Document) {
 	// We retrieved it, so set new to false
 	if newt, ok := d.(NewTracker); ok {
 		newt.SetIsNew(false)
 	}
-	return true
+
+	// Start tracking changes from the loaded state
+	if tracker, ok := d.(Trackable); ok {
+		tracker.GetDiffTracker().Reset()
+	}
 }
 
 // NextPage is the paginated version of the Next iterator. It fills
 // the results slice using the Pagination field of the Iterator.
 // Before using this the Query should be initialized using the Paginate()
-// receiver.
+// or PaginateByKey() receivers.
+// In keyset mode (PaginateByKey) it returns false when no more documents are found,
+// and Pagination.Cursor holds the token of the last loaded document.
 func (i *Iter) NextPage(results interface{}) bool {
-	var n int
-	var err error
-
 	rv := reflect.ValueOf(results)
 	if rv.Kind() != reflect.Ptr {
 		panic("results argument must be a slice")
 	}
 
-	if i.Pagination == nil {
+	if i.Pagination == nil || i.withRefs() {
 		return false
 	}
 
-	// if len(results) < i.Pagination.N {
-	// 	panic(fmt.Sprintf("passed slice size (%d) is lower than paginate size (%d)", len(results), i.Pagination.N))
-	// }
+	if i.Pagination.Key != "" {
+		return i.nextKeysetPage(results)
+	}
+
+	i.count()
+	if i.Pagination.Page >= i.Pagination.Pages {
+		i.Pagination.Page = 1
+	} else {
+		i.Pagination.Page++
+	}
+	i.loadPage(results)
+
+	if i.Pagination.Page == i.Pagination.Pages {
+		return false
+	}
+
+	return true
+}
+
+// PrevPage is the backward version of NextPage. It returns false when
+// the first page is loaded. If no page was loaded yet it starts from the
+// last one.
+func (i *Iter) PrevPage(results interface{}) bool {
+	if i.Pagination == nil || i.Pagination.Key != "" || i.withRefs() {
+		return false
+	}
+
+	i.count()
+	if i.Pagination.Page <= 1 {
+		i.Pagination.Page = i.Pagination.Pages
+	} else {
+		i.Pagination.Page--
+	}
+	i.loadPage(results)
+
+	if i.Pagination.Page <= 1 {
+		return false
+	}
+
+	return true
+}
+
+// Page loads the page p (starting from 1) in the results slice. It returns
+// false if p is out of range. Not available in keyset mode.
+func (i *Iter) Page(results interface{}, p int) bool {
+	if i.Pagination == nil || i.Pagination.Key != "" || i.withRefs() {
+		return false
+	}
+
+	i.count()
+	if p < 1 || p > i.Pagination.Pages {
+		return false
+	}
+	i.Pagination.Page = p
+	i.loadPage(results)
+
+	return true
+}
+
+// withRefs returns true, setting Err, if the iterator joins ref fields (see
+// Query.With) which cannot be paginated
+func (i *Iter) withRefs() bool {
+	if i.query == nil || len(i.query.with) == 0 {
+		return false
+	}
+
+	i.Err = errWithPagination
+	return true
+}
+
+// count initializes the pagination counters if needed
+func (i *Iter) count() {
+	var n int
+	var err error
+
+	if i.Pagination.T != 0 {
+		return
+	}
 
-	if i.Pagination.T == 0 {
+	if i.Pagination.Estimated && i.query != nil {
+		n, err = i.query.MgoC.Count()
+	} else {
 		n, err = i.MgoQ.Count()
+	}
+	if err != nil {
+		i.Err = err
+	}
+
+	i.Pagination.T = n
+	i.Pagination.Page = 0
+	i.Pagination.Pages = int(math.Ceil(float64(n) / float64(i.Pagination.N)))
+}
+
+// loadPage loads the current page using skip and limit
+func (i *Iter) loadPage(results interface{}) {
+	i.MgoQ = i.MgoQ.Skip((i.Pagination.Page - 1) * i.Pagination.N).Limit(i.Pagination.N)
+	i.MgoI.Close()
+	i.MgoI = i.MgoQ.Iter()
+
+	i.Pagination.OnPage = i.fill(results)
+}
+
+// nextKeysetPage loads the page following the document referenced by Pagination.Cursor
+func (i *Iter) nextKeysetPage(results interface{}) bool {
+	var err error
+
+	p := i.Pagination
+	q := i.query
+	if q == nil {
+		i.Err = errors.New("keyset pagination requires an Iter created by Query.Iter")
+		return false
+	}
+
+	key := strings.TrimPrefix(p.Key, "-")
+	op, prefix := "$gt", ""
+	if strings.HasPrefix(p.Key, "-") {
+		op, prefix = "$lt", "-"
+	}
+
+	filter := q.filter()
+	if p.Cursor != "" {
+		k, id, err := decodeCursor(p.Cursor)
 		if err != nil {
 			i.Err = err
+			return false
+		}
+
+		var cond bson.M
+		if key == "_id" {
+			cond = bson.M{"_id": bson.M{op: id}}
+		} else {
+			cond = bson.M{"$or": []bson.M{
+				{key: bson.M{op: k}},
+				{key: k, "_id": bson.M{op: id}},
+			}}
 		}
 
-		i.Pagination.T = n
-		i.Pagination.Page = 0
-		i.Pagination.Pages = int(math.Ceil(float64(n) / float64(i.Pagination.N)))
+		if filter == nil {
+			filter = cond
+		} else {
+			filter = bson.M{"$and": []interface{}{filter, cond}}
+		}
 	}
 
-	if i.Pagination.Page >= i.Pagination.Pages {
-		i.Pagination.Page = 1
-	} else {
-		i.Pagination.Page++
+	sort := []string{p.Key}
+	if key != "_id" {
+		sort = append(sort, prefix+"_id")
 	}
-	i.MgoQ = i.MgoQ.Skip((i.Pagination.Page - 1) * i.Pagination.N).Limit(i.Pagination.N)
+
+	mq := q.MgoC.Find(filter)
+	for _, opt := range q.options {
+		mq = opt(mq)
+	}
+	i.MgoQ = mq.Sort(sort...).Limit(p.N)
+	i.MgoI.Close()
 	i.MgoI = i.MgoQ.Iter()
 
-	r := NewDoc(results)
+	l := i.fill(results)
+	p.OnPage = l
+	if l == 0 {
+		return false
+	}
+	p.Page++
+
+	last := reflect.ValueOf(results).Elem().Index(l - 1)
+	if last.Kind() != reflect.Ptr {
+		last = last.Addr()
+	}
+
+	p.Cursor, err = encodeCursor(last.Interface().(Document), key)
+	if err != nil {
+		i.Err = err
+		return false
+	}
+
+	return true
+}
+
+// fill loads the documents returned by the iterator in the slice pointed by results
+// ([]T or []*T) and returns the number of loaded documents.
+func (i *Iter) fill(results interface{}) int {
+	rv := reflect.ValueOf(results)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		panic("results argument must be a slice address")
+	}
+
 	sv := rv.Elem()
 	sv = sv.Slice(0, sv.Cap())
+	byValue := sv.Type().Elem().Kind() != reflect.Ptr
+
+	reg := i.connection().Models()
+	r := newDoc(reg, results)
 	l := 0
-	// TODO: error management
 	for i.Next(r) {
+		v := reflect.ValueOf(r)
+		if byValue {
+			v = v.Elem()
+		}
+
 		if sv.Len() == l {
-			sv = reflect.Append(sv, reflect.ValueOf(r))
+			sv = reflect.Append(sv, v)
 			sv = sv.Slice(0, sv.Cap())
 		} else {
-			sv.Index(l).Set(reflect.ValueOf(r))
+			sv.Index(l).Set(v)
 		}
-		r = NewDoc(results)
+		r = newDoc(reg, results)
 		l++
 	}
 
+	// Documents were copied into the slice, me and the diff tracker must point
+	// to the slice element
+	if byValue {
+		for j := 0; j < l; j++ {
+			restoreMe(sv.Index(j).Addr())
+		}
+	}
 	rv.Elem().Set(sv.Slice(0, l))
-	i.Pagination.OnPage = l
 
-	if i.Pagination.Page == i.Pagination.Pages {
-		return false
+	return l
+}
+
+// encodeCursor builds the opaque cursor token of the document for the passed key
+func encodeCursor(doc Document, key string) (string, error) {
+	m, err := toBsonM(doc)
+	if err != nil {
+		return "", err
 	}
 
-	return true
+	k, _ := lookupPath(m, key)
+	raw, err := bson.Marshal(&cursorToken{Key: k, ID: doc.GetID()})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.URLEncoding.EncodeToString(raw), nil
+}
+
+// decodeCursor returns the key value and the id stored in the cursor token
+func decodeCursor(cursor string) (interface{}, bson.ObjectId, error) {
+	var token cursorToken
+
+	raw, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid pagination cursor (%s)", err.Error())
+	}
+
+	if err = bson.Unmarshal(raw, &token); err != nil {
+		return nil, "", fmt.Errorf("invalid pagination cursor (%s)", err.Error())
+	}
+
+	return token.Key, token.ID, nil
+}
+
+// Close is a wrapper around mgo.Iter.Close
+func (i *Iter) Close() error {
+	if i.MgoI == nil {
+		return nil
+	}
+
+	return i.MgoI.Close()
 }
 
 // Done is a wrapper around mgo.Iter.Done
 func (i *Iter) Done() bool {
+	if i.MgoI == nil {
+		return false
+	}
+
 	return i.MgoI.Done()
 }
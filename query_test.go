@@ -3,8 +3,10 @@ package mogo
 import (
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 
+	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -49,6 +51,102 @@ func TestQuery(t *testing.T) {
 			}
 		})
 
+		Convey("should let you jump to a page and go backwards", func() {
+			iter := doc.Find(nil).Sort("name").Paginate(3).Iter()
+			results := []*noHookDocument{}
+
+			So(iter.Page(&results, 2), ShouldBeTrue)
+			So(len(results), ShouldEqual, 3)
+			So(results[0].Name, ShouldEqual, "Number_3")
+			So(iter.Page(&results, 5), ShouldBeFalse)
+
+			So(iter.PrevPage(&results), ShouldBeFalse)
+			So(iter.Pagination.Page, ShouldEqual, 1)
+			So(results[0].Name, ShouldEqual, "Number_0")
+
+			So(iter.PrevPage(&results), ShouldBeTrue)
+			So(iter.Pagination.Page, ShouldEqual, 4)
+			So(len(results), ShouldEqual, 1)
+			So(results[0].Name, ShouldEqual, "Number_9")
+		})
+
+		Convey("should let you paginate using the estimated count", func() {
+			iter := doc.Find(bson.M{"name": "Number_1"}).Paginate(3).EstimatedCount().Iter()
+			results := []*noHookDocument{}
+
+			iter.NextPage(&results)
+			So(iter.Pagination.T, ShouldEqual, 10)
+			So(iter.Pagination.Pages, ShouldEqual, 4)
+			So(len(results), ShouldEqual, 1)
+		})
+
+		Convey("should let you paginate by key using the cursor", func() {
+			iter := doc.Find(nil).PaginateByKey(4, "-name").Iter()
+			results := []*noHookDocument{}
+			names := []string{}
+
+			So(iter.NextPage(&results), ShouldBeTrue)
+			So(len(results), ShouldEqual, 4)
+			So(results[0].Name, ShouldEqual, "Number_9")
+			cursor := iter.Pagination.Cursor
+			So(cursor, ShouldNotEqual, "")
+
+			// Restart from the serialized cursor
+			iter = doc.Find(nil).PaginateByKey(4, "-name").Cursor(cursor).Iter()
+			for iter.NextPage(&results) {
+				for i := range results {
+					names = append(names, results[i].Name)
+				}
+			}
+			So(iter.Err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"Number_5", "Number_4", "Number_3", "Number_2", "Number_1", "Number_0"})
+		})
+
+		Reset(func() {
+			DBConn.Session.DB("mogotest").DropDatabase()
+		})
+	})
+
+	Convey("Sort/projection", t, func() {
+		for i := 0; i < 5; i++ {
+			doc := NewDoc(hookedDocument{}).(*hookedDocument)
+			doc.Name = fmt.Sprintf("Number_%d", i)
+			doc.Surname = "foo"
+			Save(doc)
+		}
+
+		Convey("should sort and project results running the hooks", func() {
+			doc := NewDoc(hookedDocument{}).(*hookedDocument)
+			iter := doc.Find(nil).Sort("-name").Select("name").Limit(3).Iter()
+
+			names := []string{}
+			for iter.Next(doc) {
+				So(doc.Surname, ShouldEqual, "")
+				So(doc.RanAfterFind, ShouldBeTrue)
+				names = append(names, doc.Name)
+			}
+			So(names, ShouldResemble, []string{"Number_4", "Number_3", "Number_2"})
+		})
+
+		Convey("should keep the options when the query filter is replaced", func() {
+			doc := NewDoc(hookedDocument{}).(*hookedDocument)
+			err := doc.Find(nil).Sort("name").Omit("surname").Find(bson.M{"name": bson.M{"$gt": "Number_2"}}).One(doc)
+			So(err, ShouldBeNil)
+			So(doc.Name, ShouldEqual, "Number_3")
+			So(doc.Surname, ShouldEqual, "")
+		})
+
+		Convey("should paginate sorted results", func() {
+			doc := NewDoc(hookedDocument{}).(*hookedDocument)
+			iter := doc.Find(nil).Sort("-name").Comment("paginate").Paginate(2).Iter()
+			results := make([]*hookedDocument, 2)
+
+			iter.NextPage(&results)
+			So(len(results), ShouldEqual, 2)
+			So(results[0].Name, ShouldEqual, "Number_4")
+			So(results[1].Name, ShouldEqual, "Number_3")
+		})
+
 		Reset(func() {
 			DBConn.Session.DB("mogotest").DropDatabase()
 		})
@@ -87,6 +185,35 @@ func TestQuery(t *testing.T) {
 		Convey("should let you iterate through all results without paginating", func() {
 		})
 
+		Convey("should load all results in a slice of pointers running the hooks", func() {
+			doc := NewDoc(hookedDocument{}).(*hookedDocument)
+			results := []*hookedDocument{}
+
+			err := doc.Find(nil).All(&results)
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 10)
+			for i := range results {
+				So(results[i].RanAfterFind, ShouldBeTrue)
+				So(results[i].IsNew(), ShouldBeFalse)
+				So(results[i].AsDocument(), ShouldEqual, results[i])
+			}
+		})
+
+		Convey("should load all results in a slice of values running the hooks", func() {
+			doc := NewDoc(hookedDocument{}).(*hookedDocument)
+			results := make([]hookedDocument, 2)
+
+			err := doc.Find(nil).Limit(5).All(&results)
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 5)
+			for i := range results {
+				So(results[i].RanAfterFind, ShouldBeTrue)
+				So(results[i].IsNew(), ShouldBeFalse)
+				So(results[i].AsDocument(), ShouldEqual, &results[i])
+				So(results[i].GetColl().Name, ShouldEqual, "hooked-test")
+			}
+		})
+
 		Reset(func() {
 			DBConn.Session.DB("mogotest").DropDatabase()
 		})
@@ -182,3 +309,130 @@ func TestPopulate(t *testing.T) {
 		})
 	})
 }
+
+func TestApply(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	ModelRegistry.Register(hookedDocument{})
+
+	Convey("Apply", t, func() {
+		for i := 0; i < 3; i++ {
+			doc := NewDoc(hookedDocument{Name: fmt.Sprintf("Number_%d", i), Surname: "foo"}).(*hookedDocument)
+			So(Save(doc), ShouldBeNil)
+		}
+
+		Convey("should load the updated document running the hooks", func() {
+			doc := NewDoc(hookedDocument{}).(*hookedDocument)
+			change := mgo.Change{Update: bson.M{"$set": bson.M{"surname": "bar"}}, ReturnNew: true}
+
+			info, err := doc.Find(bson.M{"surname": "foo"}).Sort("-name").Apply(change, doc)
+			So(err, ShouldBeNil)
+			So(info.Updated, ShouldEqual, 1)
+			So(doc.GetCInfo(), ShouldEqual, info)
+			So(doc.Name, ShouldEqual, "Number_2")
+			So(doc.Surname, ShouldEqual, "bar")
+			So(doc.RanAfterFind, ShouldBeTrue)
+			So(doc.IsNew(), ShouldBeFalse)
+
+			iname, me := doc.GetMe()
+			So(iname, ShouldEqual, "github.com/goonode/mogo.hookedDocument")
+			So(me, ShouldEqual, doc)
+		})
+
+		Convey("should load the original document", func() {
+			doc := NewDoc(hookedDocument{}).(*hookedDocument)
+			change := mgo.Change{Update: bson.M{"$set": bson.M{"surname": "bar"}}}
+
+			_, err := doc.Find(bson.M{"name": "Number_0"}).Apply(change, doc)
+			So(err, ShouldBeNil)
+			So(doc.Surname, ShouldEqual, "foo")
+
+			_, err = doc.Find(bson.M{"name": "Number_9"}).Apply(change, doc)
+			So(err, ShouldEqual, mgo.ErrNotFound)
+		})
+
+		Convey("should upsert and remove documents", func() {
+			doc := NewDoc(hookedDocument{}).(*hookedDocument)
+			change := mgo.Change{Update: bson.M{"$set": bson.M{"surname": "new"}}, Upsert: true}
+
+			info, err := doc.Find(bson.M{"name": "Number_9"}).Apply(change, doc)
+			So(err, ShouldBeNil)
+			So(info.UpsertedId, ShouldNotBeNil)
+			So(doc.RanAfterFind, ShouldBeFalse)
+			So(doc.IsNew(), ShouldBeTrue)
+
+			info, err = doc.Find(bson.M{"name": "Number_9"}).Apply(mgo.Change{Remove: true}, doc)
+			So(err, ShouldBeNil)
+			So(info.Removed, ShouldEqual, 1)
+			So(doc.GetCInfo(), ShouldEqual, info)
+			So(doc.Surname, ShouldEqual, "new")
+			So(doc.RanAfterFind, ShouldBeFalse)
+			So(doc.RanBeforeDelete, ShouldBeTrue)
+			So(doc.RanAfterDelete, ShouldBeTrue)
+			So(doc.IsNew(), ShouldBeTrue)
+
+			_, err = doc.Find(bson.M{"name": "Number_9"}).Apply(mgo.Change{Remove: true}, doc)
+			So(err, ShouldEqual, mgo.ErrNotFound)
+		})
+
+		Convey("should remove each document once with concurrent calls", func() {
+			for i := 3; i < 20; i++ {
+				doc := NewDoc(hookedDocument{Name: fmt.Sprintf("Number_%d", i), Surname: "foo"}).(*hookedDocument)
+				So(Save(doc), ShouldBeNil)
+			}
+
+			var wg sync.WaitGroup
+			popped := make([][]string, 2)
+			errs := make([]error, 2)
+			for w := range popped {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for {
+						doc := NewDoc(hookedDocument{}).(*hookedDocument)
+						_, err := doc.Find(bson.M{"surname": "foo"}).Sort("name").Apply(mgo.Change{Remove: true}, doc)
+						if err != nil {
+							errs[w] = err
+							return
+						}
+						popped[w] = append(popped[w], doc.Name)
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			So(errs, ShouldResemble, []error{mgo.ErrNotFound, mgo.ErrNotFound})
+			seen := map[string]int{}
+			for _, names := range popped {
+				for _, name := range names {
+					seen[name]++
+				}
+			}
+			So(seen, ShouldHaveLength, 20)
+			for name, n := range seen {
+				So(fmt.Sprintf("%s: %d", name, n), ShouldEqual, fmt.Sprintf("%s: 1", name))
+			}
+		})
+
+		Convey("should soft delete the documents of soft delete models", func() {
+			conn.Session.DB("mogotest").C("notes").RemoveAll(nil)
+			ModelRegistry.Register(Note{})
+			So(Save(NewDoc(Note{Text: "a"}).(*Note)), ShouldBeNil)
+
+			n := NewDoc(Note{}).(*Note)
+			info, err := n.Find(bson.M{"text": "a"}).Apply(mgo.Change{Remove: true}, n)
+			So(err, ShouldBeNil)
+			So(info.Updated, ShouldEqual, 1)
+			So(n.IsDeleted(), ShouldBeTrue)
+			So(n.IsNew(), ShouldBeFalse)
+
+			count, _ := conn.Session.DB("mogotest").C("notes").Count()
+			So(count, ShouldEqual, 1)
+		})
+
+		Reset(func() {
+			DBConn.Session.DB("mogotest").DropDatabase()
+		})
+	})
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/globalsign/mgo"
@@ -15,6 +18,10 @@ type Config struct {
 	ConnectionString string
 	Database         string
 	DialInfo         *mgo.DialInfo
+
+	// TxnCollection is the journal collection used by WithTransaction
+	// (DefaultTxnCollection if empty)
+	TxnCollection string
 }
 
 // var EncryptionKey [32]byte
@@ -25,6 +32,13 @@ type Connection struct {
 	Config  *Config
 	Session *mgo.Session
 	Context *Context
+
+	// Registry contains the models used with the connection, ModelRegistry
+	// if nil (see NewConnection)
+	Registry ModelReg
+
+	// plugins wrapping all the operations (see Use)
+	plugins []Plugin
 }
 
 // Registry ...
@@ -52,19 +66,63 @@ type ModelInternals struct {
 	Collection string
 	Indexes    map[string][]ParsedIndex
 	Refs       map[string]RefIndex
+
+	// Versioned is true if the model uses the _version field for
+	// optimistic concurrency control (version tag on DocumentModel)
+	Versioned bool
+
+	// SoftDelete is true if removed documents are only marked with the _deleted
+	// time (softdelete tag on DocumentModel)
+	SoftDelete bool
+
+	// Plugins wrapping the operations on the model (see ModelReg.Use)
+	Plugins []Plugin
+
+	// Rules are the validation rules of the validate tags
+	Rules []FieldRules
 }
 
 // ModelReg ...
 type ModelReg map[string]*ModelInternals
 
-// ModelRegistry is the centralized registry of all models used for the app
+// ModelRegistry is the centralized registry of all models used for the app.
+// It is the registry of the connections without their own registry.
 var ModelRegistry = make(ModelReg, 0)
 
+// NewRegistry returns an empty registry, to be used as the registry of
+// a connection (see NewConnection)
+func NewRegistry() ModelReg {
+	return make(ModelReg, 0)
+}
+
+// reg returns the registry used by the methods of r, the nil registry
+// is ModelRegistry
+func (r ModelReg) reg() ModelReg {
+	if r == nil {
+		return ModelRegistry
+	}
+
+	return r
+}
+
+// registryOf returns the registry of the document model (the registry
+// of the connection it is bound to) or ModelRegistry for the values which
+// are not documents
+func registryOf(doc interface{}) ModelReg {
+	if d, ok := doc.(interface {
+		models() ModelReg
+	}); ok {
+		return d.models()
+	}
+
+	return ModelRegistry
+}
+
 // DBConn is the connection initialized after Connect is called.
 // All underlying operations are made using this connection
 var DBConn *Connection
 
-var mu sync.Mutex
+var mu sync.RWMutex
 
 // Connect creates a new connection and run Connect()
 func Connect(config *Config) (*Connection, error) {
@@ -86,93 +144,375 @@ func Connect(config *Config) (*Connection, error) {
 	return conn, err
 }
 
-// Register ...
+// ModelError is a problem in the definition of a model found by the
+// registration. Field is empty for the problems of the whole model.
+type ModelError struct {
+	Model   string
+	Field   string
+	Message string
+}
+
+func (e *ModelError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", e.Model, e.Message)
+	}
+
+	return fmt.Sprintf("%s.%s: %s", e.Model, e.Field, e.Message)
+}
+
+// RegistrationError contains all the problems found by RegisterE
+type RegistrationError struct {
+	Errors []*ModelError
+}
+
+func (e *RegistrationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("cannot register the document models (%d problems): %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// ErrModelNotFound is returned by Lookup when no registered model has the
+// passed name
+var ErrModelNotFound = errors.New("the document model is not registered")
+
+// AmbiguousModelError is returned by Lookup when a short name matches more
+// than one registered model
+type AmbiguousModelError struct {
+	Name   string
+	Models []string
+}
+
+func (e *AmbiguousModelError) Error() string {
+	return fmt.Sprintf("model name %s is ambiguous (models: %s)", e.Name, strings.Join(e.Models, ", "))
+}
+
+// modelErrors collects the problems of a model during the registration.
+// A collection tag outside the DocumentModel is a problem only if strict,
+// otherwise it is logged.
+type modelErrors struct {
+	model  string
+	strict bool
+	errs   []*ModelError
+}
+
+func (m *modelErrors) add(field string, format string, args ...interface{}) {
+	m.errs = append(m.errs, &ModelError{Model: m.model, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// NewConnection creates a new connection using its own registry, or the
+// passed one if not nil, and connects it. Unlike Connect it does not change
+// DBConn, so the documents must be created with Connection.NewDoc or bound
+// with SetConn. Each connection can use a different database or cluster.
+func NewConnection(config *Config, registry ModelReg) (*Connection, error) {
+	if registry == nil {
+		registry = NewRegistry()
+	}
+
+	conn := &Connection{
+		Config:   config,
+		Context:  &Context{},
+		Registry: registry,
+	}
+
+	err := conn.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Models returns the registry of the connection models
+func (m *Connection) Models() ModelReg {
+	if m == nil || m.Registry == nil {
+		return ModelRegistry
+	}
+
+	return m.Registry
+}
+
+// Register adds the passed document models to the registry. It panics if a
+// model is not valid, RegisterE returns the problems instead.
 func (r ModelReg) Register(i ...interface{}) {
+	if err := r.register(i, false); err != nil {
+		panic(err.Error())
+	}
+}
+
+// RegisterE is the same as Register, but it checks all the passed models and
+// returns a *RegistrationError with all the problems found, if any. Besides the
+// problems making Register panic, it reports the collection tags used outside
+// the DocumentModel and the ref tags referring to models not registered yet.
+// If a model is not valid no model is registered, while the models with
+// unresolved refs are registered anyway (a later call can register the
+// missing models).
+func (r ModelReg) RegisterE(i ...interface{}) error {
+	if err := r.register(i, true); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r ModelReg) register(models []interface{}, strict bool) *RegistrationError {
 	defer mu.Unlock()
 
 	mu.Lock()
-	for p, o := range i {
-		t := reflect.TypeOf(o)
-		v := reflect.ValueOf(o)
-
-		if t.Kind() == reflect.Ptr {
-			t = reflect.Indirect(reflect.ValueOf(o)).Type()
-			v = reflect.ValueOf(o).Elem()
+	reg := r.reg()
+	rerr := &RegistrationError{}
+	names := []string{}
+	internals := map[string]*ModelInternals{}
+	for p, o := range models {
+		n, ri, errs := inspectModel(p, o, strict)
+		if len(errs) > 0 {
+			rerr.Errors = append(rerr.Errors, errs...)
+			continue
 		}
-		n := t.Name()
-		if t.Kind() != reflect.Struct {
-			panic(fmt.Sprintf("Only type struct can be used as document model (passed type %s (pos: %d) is not struct)", n, p))
+
+		names = append(names, n)
+		internals[n] = ri
+	}
+
+	if len(rerr.Errors) > 0 {
+		return rerr
+	}
+
+	// Second Pass to validate all defined Refs, before changing the registry
+	// so that an ambiguous short name leaves it untouched
+	next := make(ModelReg, len(reg)+len(internals))
+	for n, ri := range reg {
+		next[n] = ri
+	}
+	for n, ri := range internals {
+		next[n] = ri
+	}
+
+	refs, ambiguous := next.resolveRefs()
+	if len(ambiguous) > 0 {
+		rerr.Errors = ambiguous
+		return rerr
+	}
+
+	for n, ri := range internals {
+		reg[n] = ri
+	}
+	for n, r := range refs {
+		reg[n].Refs = r
+	}
+
+	if !strict {
+		return nil
+	}
+
+	for _, n := range names {
+		fields := make([]string, 0, len(reg[n].Refs))
+		for f := range reg[n].Refs {
+			fields = append(fields, f)
 		}
-		var idx = -1
-		for i := 0; i < v.NumField(); i++ {
-			ft := t.Field(i)
-			if ft.Type.ConvertibleTo(reflect.TypeOf(DocumentModel{})) {
-				idx = i
-				break
+		sort.Strings(fields)
+
+		for _, f := range fields {
+			if ref := reg[n].Refs[f]; !ref.Exists {
+				rerr.Errors = append(rerr.Errors, &ModelError{Model: n, Field: f, Message: fmt.Sprintf("ref tag refers to the model %s which is not registered", ref.Tag)})
 			}
 		}
+	}
+
+	if len(rerr.Errors) > 0 {
+		return rerr
+	}
+
+	return nil
+}
 
-		if idx == -1 {
-			panic(fmt.Sprintf("A document model must embed a DocumentModel type field (passed type %s (pos: %d) does not have)", n, p))
+// resolveRefs resolves the ref tags of all the models in r, returning the
+// resolved refs by model and the problems of the ambiguous short names
+func (r ModelReg) resolveRefs() (map[string]map[string]RefIndex, []*ModelError) {
+	models := make([]string, 0, len(r))
+	for n := range r {
+		models = append(models, n)
+	}
+	sort.Strings(models)
+
+	resolved := make(map[string]map[string]RefIndex, len(r))
+	var errs []*ModelError
+	for _, n := range models {
+		fields := make([]string, 0, len(r[n].Refs))
+		for f := range r[n].Refs {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+
+		refs := make(map[string]RefIndex, len(fields))
+		for _, f := range fields {
+			ref := r[n].Refs[f]
+			ref.Model = n
+
+			name, matches := r.resolve(ref.Tag)
+			switch {
+			case len(matches) > 1:
+				errs = append(errs, &ModelError{Model: n, Field: f, Message: fmt.Sprintf("ref tag %s is ambiguous (models: %s)", ref.Tag, strings.Join(matches, ", "))})
+			case name != "":
+				ref.Ref, ref.Exists = name, true
+			default:
+				ref.Ref, ref.Exists = ref.Tag, false
+			}
+			refs[f] = ref
 		}
+		resolved[n] = refs
+	}
 
-		pi, refs, coll := initializeTags(t, v)
-		if coll == "" {
-			panic(fmt.Sprintf("The document model does not have a collection name (passed type %s)", n))
+	return resolved, errs
+}
+
+// resolve returns the registry name of the model named n, that is the
+// qualified name of the model or its unambiguous short name. If n is an
+// ambiguous short name the name is empty and the matching models are
+// returned.
+func (r ModelReg) resolve(n string) (string, []string) {
+	if _, ok := r[n]; ok {
+		return n, nil
+	}
+
+	var matches []string
+	for k, v := range r {
+		if v.Type.Name() == n {
+			matches = append(matches, k)
 		}
+	}
+	sort.Strings(matches)
 
-		ModelRegistry[n] = &ModelInternals{
-			Idx:        idx,
-			Type:       t,
-			Collection: coll,
-			Indexes:    pi,
-			Refs:       refs}
-	}
-
-	for k, v := range ModelRegistry {
-		// TODO: Second Pass to validate all defined Refs
-		for kk, vv := range v.Refs {
-			if !vv.Exists {
-				if _, ok := ModelRegistry[vv.Ref]; ok {
-					ModelRegistry[k].Refs[kk] = RefIndex{
-						Model:  k,
-						Idx:    ModelRegistry[k].Refs[kk].Idx,
-						Ref:    ModelRegistry[k].Refs[kk].Ref,
-						Kind:   ModelRegistry[k].Refs[kk].Kind,
-						Type:   ModelRegistry[k].Refs[kk].Type,
-						Exists: true,
-					}
-				}
-			}
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+
+	return "", matches
+}
+
+// modelName returns the registry name of the type, made by the package path
+// and the type name (i.e. github.com/org/app/models.User)
+func modelName(t reflect.Type) string {
+	if t.PkgPath() == "" {
+		return t.Name()
+	}
+
+	return t.PkgPath() + "." + t.Name()
+}
+
+// inspectModel returns the name and the internals of the model o, passed at
+// position p, or the problems of its definition
+func inspectModel(p int, o interface{}, strict bool) (string, *ModelInternals, []*ModelError) {
+	if o == nil {
+		return "", nil, []*ModelError{{Model: fmt.Sprintf("(pos: %d)", p), Message: "a nil value cannot be used as document model"}}
+	}
+
+	t := reflect.TypeOf(o)
+	v := reflect.ValueOf(o)
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		v = reflect.New(t).Elem()
+	}
+	n := modelName(t)
+
+	me := &modelErrors{model: n, strict: strict}
+	if n == "" {
+		me.model = fmt.Sprintf("%s (pos: %d)", t.String(), p)
+	}
+	if t.Kind() != reflect.Struct {
+		me.add("", "Only type struct can be used as document model (passed type %s (pos: %d) is not struct)", n, p)
+		return n, nil, me.errs
+	}
+
+	var idx = -1
+	for i := 0; i < v.NumField(); i++ {
+		ft := t.Field(i)
+		if ft.Type.ConvertibleTo(reflect.TypeOf(DocumentModel{})) {
+			idx = i
+			break
 		}
 	}
+
+	if idx == -1 {
+		me.add("", "A document model must embed a DocumentModel type field (passed type %s (pos: %d) does not have)", n, p)
+		return n, nil, me.errs
+	}
+
+	pi, refs, rules, coll := initializeTags(t, v, me)
+	if coll == "" {
+		me.add("", "The document model does not have a collection name (passed type %s)", n)
+	}
+
+	if len(me.errs) > 0 {
+		return n, nil, me.errs
+	}
+
+	return n, &ModelInternals{
+		Idx:        idx,
+		Type:       t,
+		Collection: coll,
+		Indexes:    pi,
+		Refs:       refs,
+		Rules:      rules,
+		Versioned:  extractVersioned(t.Field(idx)),
+		SoftDelete: extractSoftDelete(t.Field(idx))}, nil
 }
 
-// Exists ...
+// Exists returns the registry name and the internals of the model of the
+// passed document (struct or pointer to struct)
 func (r ModelReg) Exists(i interface{}) (string, *ModelInternals, bool) {
+	reg := r.reg()
 	t := reflect.TypeOf(i)
 	if t.Kind() == reflect.Ptr {
 		t = reflect.Indirect(reflect.ValueOf(i)).Type()
 	}
-	n := t.Name()
+	n := modelName(t)
 
-	if rT, ok := ModelRegistry[n]; ok {
+	if rT, ok := reg[n]; ok {
 		return n, rT, true
 	}
 	return "", nil, false
 }
 
-// ExistsByName ...
+// ExistsByName returns the registry name and the internals of the model
+// named n. The name is the qualified name of the model (package path and
+// type name) or the type name, if there is only one registered model with
+// that name. An ambiguous type name is not found, Lookup tells it apart.
 func (r ModelReg) ExistsByName(n string) (string, *ModelInternals, bool) {
-	if t, ok := ModelRegistry[n]; ok {
-		return n, t, true
+	n, ri, err := r.Lookup(n)
+	return n, ri, err == nil
+}
+
+// Lookup is the same as ExistsByName, but it returns ErrModelNotFound if no
+// model is named n, or an *AmbiguousModelError if n is the type name of more
+// registered models.
+func (r ModelReg) Lookup(n string) (string, *ModelInternals, error) {
+	reg := r.reg()
+
+	name, matches := reg.resolve(n)
+	switch {
+	case name != "":
+		return name, reg[name], nil
+	case len(matches) > 1:
+		return "", nil, &AmbiguousModelError{Name: n, Models: matches}
 	}
-	return "", nil, false
+
+	return "", nil, ErrModelNotFound
+}
+
+// Model returns the internals of the model named n (see ExistsByName)
+// or nil if not found
+func (r ModelReg) Model(n string) *ModelInternals {
+	_, v, _ := r.ExistsByName(n)
+	return v
 }
 
 // TypeOf ...
 func (r ModelReg) TypeOf(n string) reflect.Type {
-	if v, ok := ModelRegistry[n]; ok {
+	if _, v, ok := r.ExistsByName(n); ok {
 		return v.Type
 	}
 	return nil
@@ -181,7 +521,7 @@ func (r ModelReg) TypeOf(n string) reflect.Type {
 // Index returns the index of the DocumentModel field in the struct
 // or -1 if the struct name passed is not found
 func (r ModelReg) Index(n string) int {
-	if v, ok := ModelRegistry[n]; ok {
+	if _, v, ok := r.ExistsByName(n); ok {
 		return v.Idx
 	}
 	return -1
@@ -190,7 +530,7 @@ func (r ModelReg) Index(n string) int {
 // Refs returns the Refs of the DocumentModel field in the struct
 // or nil if the struct name passed is not found
 func (r ModelReg) Refs(n string) map[string]RefIndex {
-	if v, ok := ModelRegistry[n]; ok {
+	if _, v, ok := r.ExistsByName(n); ok {
 		return v.Refs
 	}
 
@@ -213,7 +553,7 @@ func (r ModelReg) SearchRef(i interface{}, n string) (*ModelInternals, *RefIndex
 
 // New ...
 func (r ModelReg) New(n string) interface{} {
-	if n, m, ok := ModelRegistry.ExistsByName(n); ok {
+	if n, m, ok := r.ExistsByName(n); ok {
 		v := reflect.New(m.Type)
 
 		df := v.Elem().Field(m.Idx)
@@ -283,31 +623,22 @@ func (m *Connection) Collection(name string) *Collection {
 	return m.CollectionFromDatabase(name, m.Config.Database)
 }
 
-func buildRefIndex(idx int, tag string, fname string, t reflect.Type) RefIndex {
-	if tag != "" {
-		if ModelRegistry.Index(tag) == -1 {
-			return RefIndex{
-				Idx:    idx,
-				Ref:    tag,
-				Kind:   t.Kind(),
-				Type:   t,
-				Exists: false,
-			}
-		}
-
-		return RefIndex{
-			Idx:    idx,
-			Ref:    tag,
-			Kind:   t.Kind(),
-			Type:   t,
-			Exists: true,
-		}
+func buildRefIndex(idx int, tag string, fname string, t reflect.Type, me *modelErrors) RefIndex {
+	if tag == "" {
+		me.add(fname, "ref tag is missing on RefField field")
 	}
 
-	panic(fmt.Sprintf("ref tag is missing on RefField field (type: %s)", fname))
+	// Ref and Exists are set resolving the tag after the registration
+	return RefIndex{
+		Idx:  idx,
+		Tag:  tag,
+		Ref:  tag,
+		Kind: t.Kind(),
+		Type: t,
+	}
 }
 
-func initializeTags(t reflect.Type, v reflect.Value) (map[string][]ParsedIndex, map[string]RefIndex, string) {
+func initializeTags(t reflect.Type, v reflect.Value, me *modelErrors) (map[string][]ParsedIndex, map[string]RefIndex, []FieldRules, string) {
 	var coll = ""
 	var pi = make(map[string][]ParsedIndex, 0)
 	var ref = make(map[string]RefIndex, 0)
@@ -320,33 +651,59 @@ func initializeTags(t reflect.Type, v reflect.Value) (map[string][]ParsedIndex,
 		case reflect.Struct:
 			if ft.Type.ConvertibleTo(reflect.TypeOf(DocumentModel{})) {
 				coll = extractColl(ft)
-				pi[ft.Type.Name()] = IndexScan(extractIdx(ft))
+				pi[ft.Type.Name()] = parseIdx(ft, me)
 				break
 			}
 			if ft.Type.ConvertibleTo(reflect.TypeOf(RefField{})) {
-				r := buildRefIndex(i, extractRef(ft), ft.Name, ft.Type)
+				r := buildRefIndex(i, extractRef(ft), ft.Name, ft.Type, me)
+				r.OnDelete = extractOnDelete(ft, me)
 				ref[ft.Name] = r
 			}
 			fallthrough
 		case reflect.Slice:
 			if ft.Type.ConvertibleTo(reflect.TypeOf([]RefField{})) || ft.Type.ConvertibleTo(reflect.TypeOf([]*RefField{})) {
-				r := buildRefIndex(i, extractRef(ft), t.Name(), ft.Type)
+				r := buildRefIndex(i, extractRef(ft), ft.Name, ft.Type, me)
+				r.OnDelete = extractOnDelete(ft, me)
 				ref[ft.Name] = r
 			}
 			fallthrough
 		default:
-			pi[ft.Name] = IndexScan(extractIdx(ft))
-			logBadColl(ft)
+			pi[ft.Name] = parseIdx(ft, me)
+			logBadColl(ft, me)
+		}
+	}
+
+	// Companion fields filled by PopulateAll with the referenced documents
+	for i := 0; i < v.NumField(); i++ {
+		ft := t.Field(i)
+		if p := extractPopulate(ft); p != "" {
+			r, ok := ref[p]
+			if !ok {
+				me.add(ft.Name, "populate tag refers to a field which is not a ref field (populate: %s)", p)
+				continue
+			}
+			r.Target = ft.Name
+			ref[p] = r
 		}
 	}
 
-	return pi, ref, coll
+	// Validation rules, parsed after the refs used by the ref rule
+	rules := structRules(t, ref, map[reflect.Type]bool{}, me)
+
+	return pi, ref, rules, coll
 }
 
-func logBadColl(sf reflect.StructField) {
-	if extractColl(sf) != "" {
-		log.Printf("Tag collection used outside DocumentModel is ignored (field: %s)", sf.Name)
+func logBadColl(sf reflect.StructField, me *modelErrors) {
+	if extractColl(sf) == "" {
+		return
 	}
+
+	if me.strict {
+		me.add(sf.Name, "collection tag used outside DocumentModel")
+		return
+	}
+
+	log.Printf("Tag collection used outside DocumentModel is ignored (field: %s)", sf.Name)
 }
 
 func extractColl(sf reflect.StructField) string {
@@ -367,6 +724,46 @@ func extractIdx(sf reflect.StructField) string {
 	return idx
 }
 
+// parseIdx parses the idx tag of the field. The unknown options are errors
+// in strict mode, otherwise they are logged and ignored.
+func parseIdx(sf reflect.StructField, me *modelErrors) []ParsedIndex {
+	pi, ignored, err := parseIndex(extractIdx(sf), me.strict)
+	if err != nil {
+		me.add(sf.Name, "%v", err)
+	}
+
+	for _, e := range ignored {
+		log.Printf("Unknown index option is ignored (field: %s): %v", sf.Name, e)
+	}
+
+	return pi
+}
+
+func extractOnDelete(sf reflect.StructField, me *modelErrors) string {
+	p := sf.Tag.Get("ondelete")
+	switch p {
+	case "", OnDeleteCascade, OnDeleteNullify, OnDeleteRestrict:
+		return p
+	}
+
+	me.add(sf.Name, "unknown ondelete policy %s", p)
+	return ""
+}
+
+func extractPopulate(sf reflect.StructField) string {
+	return sf.Tag.Get("populate")
+}
+
+func extractVersioned(sf reflect.StructField) bool {
+	v, err := strconv.ParseBool(sf.Tag.Get("version"))
+	return err == nil && v
+}
+
+func extractSoftDelete(sf reflect.StructField) bool {
+	v, err := strconv.ParseBool(sf.Tag.Get("softdelete"))
+	return err == nil && v
+}
+
 func extractRef(sf reflect.StructField) string {
 	ref := sf.Tag.Get("ref")
 	if ref == "" {
@@ -3,8 +3,10 @@ package mogo
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/globalsign/mgo/bson"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -27,10 +29,10 @@ func TestRegister(t *testing.T) {
 		hookedDocument{})
 	Convey("should register the passed interfaces", t, func() {
 		n, _, b := mr.Exists(noHookDocument{})
-		So(n, ShouldEqual, "noHookDocument")
+		So(n, ShouldEqual, "github.com/goonode/mogo.noHookDocument")
 		So(b, ShouldBeTrue)
 		n, _, b = mr.Exists(hookedDocument{})
-		So(n, ShouldEqual, "hookedDocument")
+		So(n, ShouldEqual, "github.com/goonode/mogo.hookedDocument")
 		So(b, ShouldBeTrue)
 		n, _, b = mr.Exists(DocumentChild{})
 		So(n, ShouldEqual, "")
@@ -57,8 +59,8 @@ func TestRegisterRef(t *testing.T) {
 		So(ok, ShouldBeTrue)
 		_, _, ok = ModelRegistry.Exists(Macao{})
 		So(ok, ShouldBeTrue)
-		So(ModelRegistry["mogo"].Refs["Friends"].Ref, ShouldEqual, "Macao")
-		So(ModelRegistry["mogo"].Refs["Friends"].Exists, ShouldBeTrue)
+		So(ModelRegistry.Model("Bongo").Refs["Friends"].Ref, ShouldEqual, "github.com/goonode/mogo.Macao")
+		So(ModelRegistry.Model("Bongo").Refs["Friends"].Exists, ShouldBeTrue)
 	})
 }
 
@@ -92,3 +94,237 @@ func TestInterfaceNameFunc(t *testing.T) {
 	})
 
 }
+
+type badTags struct {
+	DocumentModel `bson:",inline" coll:"bad-tags"`
+	Name          string   `idx:"{name},uniq"`
+	Address       string   `coll:"addresses"`
+	Owner         RefField `ref:"Missing" ondelete:"explode"`
+	OwnerDoc      *Macao   `populate:"Name"`
+	Age           int      `validate:"min=x"`
+}
+
+type unknownIdxOption struct {
+	DocumentModel `bson:",inline" coll:"unknown-idx-option"`
+	Name          string `idx:"{name},unique,uniq"`
+}
+
+type noColl struct {
+	DocumentModel `bson:",inline"`
+}
+
+type danglingRef struct {
+	DocumentModel `bson:",inline" coll:"dangling"`
+	Owner         RefField      `ref:"Missing"`
+	Friends       RefFieldSlice `ref:"Macao"`
+}
+
+func TestRegisterE(t *testing.T) {
+	Convey("should return all the problems of the models", t, func() {
+		err := ModelRegistry.RegisterE(badTags{}, BadDocument{}, 3, noColl{}, nil)
+		So(err, ShouldHaveSameTypeAs, &RegistrationError{})
+
+		msgs := []string{}
+		for _, e := range err.(*RegistrationError).Errors {
+			msgs = append(msgs, strings.TrimPrefix(e.Model, "github.com/goonode/mogo.")+"."+e.Field)
+		}
+		So(msgs, ShouldResemble, []string{
+			"badTags.Name",
+			"badTags.Address",
+			"badTags.Owner",
+			"badTags.OwnerDoc",
+			"badTags.Age",
+			"BadDocument.",
+			"int.",
+			"noColl.",
+			"(pos: 4).",
+		})
+
+		_, _, ok := ModelRegistry.Exists(noColl{})
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("should register the models and report the unresolved refs", t, func() {
+		ModelRegistry.Register(Macao{})
+
+		err := ModelRegistry.RegisterE(danglingRef{})
+		So(err, ShouldNotBeNil)
+		errs := err.(*RegistrationError).Errors
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Field, ShouldEqual, "Owner")
+		So(errs[0].Error(), ShouldEqual, "github.com/goonode/mogo.danglingRef.Owner: ref tag refers to the model Missing which is not registered")
+
+		_, ri, ok := ModelRegistry.Exists(danglingRef{})
+		So(ok, ShouldBeTrue)
+		So(ri.Refs["Friends"].Exists, ShouldBeTrue)
+
+		So(ModelRegistry.RegisterE(&Bongo{}, Macao{}), ShouldBeNil)
+		So(func() { ModelRegistry.Register(badTags{}) }, ShouldPanic)
+	})
+
+	Convey("should ignore the unknown index options only out of strict mode", t, func() {
+		err := ModelRegistry.RegisterE(unknownIdxOption{})
+		So(err, ShouldNotBeNil)
+		So(err.(*RegistrationError).Errors[0].Field, ShouldEqual, "Name")
+
+		So(func() { ModelRegistry.Register(unknownIdxOption{}) }, ShouldNotPanic)
+		_, ri, ok := ModelRegistry.Exists(unknownIdxOption{})
+		So(ok, ShouldBeTrue)
+		So(ri.Indexes["Name"], ShouldResemble, []ParsedIndex{{Fields: []string{"name"}, Options: []string{"unique"}}})
+	})
+}
+
+func TestQualifiedNames(t *testing.T) {
+	Convey("should key the models by package path and type name", t, func() {
+		ModelRegistry.Register(Bongo{}, Macao{})
+
+		n, _, ok := ModelRegistry.ExistsByName("Macao")
+		So(ok, ShouldBeTrue)
+		So(n, ShouldEqual, "github.com/goonode/mogo.Macao")
+		n, _, ok = ModelRegistry.ExistsByName("github.com/goonode/mogo.Macao")
+		So(ok, ShouldBeTrue)
+		So(ModelRegistry.TypeOf("Macao"), ShouldEqual, reflect.TypeOf(Macao{}))
+
+		d := NewDoc(Macao{}).(*Macao)
+		iname, _ := d.GetMe()
+		So(iname, ShouldEqual, n)
+		d = NewDoc(&[]*Macao{}).(*Macao)
+		iname, _ = d.GetMe()
+		So(iname, ShouldEqual, n)
+	})
+
+	Convey("should detect the ambiguous short names", t, func() {
+		// two models with the same type name in different packages
+		macao := reflect.TypeOf(Macao{})
+		r := ModelReg{
+			"example.com/a.Macao": {Type: macao, Refs: map[string]RefIndex{}},
+			"example.com/b.Macao": {Type: macao, Refs: map[string]RefIndex{}},
+			"example.com/a.Bongo": {Type: reflect.TypeOf(Bongo{}), Refs: map[string]RefIndex{
+				"BestFriend": {Tag: "Macao"},
+				"Friends":    {Tag: "example.com/b.Macao"},
+			}},
+		}
+
+		n, matches := r.resolve("Macao")
+		So(n, ShouldEqual, "")
+		So(matches, ShouldResemble, []string{"example.com/a.Macao", "example.com/b.Macao"})
+
+		_, _, ok := r.ExistsByName("Macao")
+		So(ok, ShouldBeFalse)
+		_, _, err := r.Lookup("Macao")
+		So(err, ShouldResemble, &AmbiguousModelError{Name: "Macao", Models: matches})
+		So(err.Error(), ShouldEqual, "model name Macao is ambiguous (models: example.com/a.Macao, example.com/b.Macao)")
+		_, _, err = r.Lookup("Parrot")
+		So(err, ShouldEqual, ErrModelNotFound)
+		n, _, err = r.Lookup("Bongo")
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, "example.com/a.Bongo")
+
+		refs, errs := r.resolveRefs()
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldEqual, "example.com/a.Bongo.BestFriend: ref tag Macao is ambiguous (models: example.com/a.Macao, example.com/b.Macao)")
+		So(refs["example.com/a.Bongo"]["Friends"].Ref, ShouldEqual, "example.com/b.Macao")
+		So(refs["example.com/a.Bongo"]["Friends"].Exists, ShouldBeTrue)
+	})
+}
+
+func TestConnectionRegistry(t *testing.T) {
+	Convey("Connections should use their own registry", t, func() {
+		a := &Connection{Config: &Config{Database: "db-a"}, Registry: NewRegistry()}
+		b := &Connection{Config: &Config{Database: "db-b"}, Registry: NewRegistry()}
+
+		d := a.NewDoc(Bongo{Name: "x"}).(*Bongo)
+		So(d.GetConn(), ShouldEqual, a)
+		_, _, ok := a.Models().Exists(Bongo{})
+		So(ok, ShouldBeTrue)
+		_, _, ok = b.Models().Exists(Bongo{})
+		So(ok, ShouldBeFalse)
+
+		// Macao is registered later, the ref of Bongo is resolved in the registry of a
+		So(a.Models().Model("Bongo").Refs["Friends"].Exists, ShouldBeFalse)
+		a.Models().Register(Macao{})
+		So(a.Models().Model("Bongo").Refs["Friends"].Exists, ShouldBeTrue)
+
+		So(func() { d.SetConn(b); d.GetColl() }, ShouldPanic)
+		d.SetConn(a)
+
+		Convey("documents should follow their binding", func() {
+			c := d.GetColl()
+			So(c.Connection, ShouldEqual, a)
+			So(c.Database, ShouldEqual, "db-a")
+			So(c.Name, ShouldEqual, "mogo-registry")
+
+			d.SetDatabase("db-c")
+			d.SetCollName("archive")
+			c = d.GetColl()
+			So(c.Database, ShouldEqual, "db-c")
+			So(c.Name, ShouldEqual, "archive")
+			So(d.GetCollName(), ShouldEqual, "archive")
+
+			m := a.Models().New("Macao").(*Macao)
+			bindLike(m, d)
+			So(m.GetColl().Database, ShouldEqual, "db-c")
+			So(m.GetColl().Name, ShouldEqual, "mogo-registry")
+
+			d.SetDatabase("")
+			d.SetCollName("")
+			So(d.GetColl().Database, ShouldEqual, "db-a")
+		})
+	})
+}
+
+func TestBoundDocuments(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	other, err := NewConnection(&Config{ConnectionString: "localhost", Database: "mogotest-other"}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer other.Session.Close()
+	other.Registry.Register(Bongo{}, Macao{})
+
+	Convey("Documents should be saved, found and populated using their connection", t, func() {
+		other.Session.DB("mogotest-other").C("mogo-registry").RemoveAll(nil)
+		conn.Session.DB("mogotest").C("mogo-registry").RemoveAll(nil)
+
+		m := other.NewDoc(Macao{Name: "m"}).(*Macao)
+		So(m.Save(), ShouldBeNil)
+		b := other.NewDoc(Bongo{Name: "b", BestFriend: RefField{ID: m.ID}}).(*Bongo)
+		So(Save(b), ShouldBeNil)
+
+		n, _ := conn.Session.DB("mogotest").C("mogo-registry").Count()
+		So(n, ShouldEqual, 0)
+		n, _ = other.Session.DB("mogotest-other").C("mogo-registry").Count()
+		So(n, ShouldEqual, 2)
+
+		found := []*Bongo{}
+		So(Find(b, bson.M{"name": "b"}).All(&found), ShouldBeNil)
+		So(len(found), ShouldEqual, 1)
+		So(found[0].GetConn(), ShouldEqual, other)
+
+		friend := other.NewDoc(Macao{}).(*Macao)
+		So(found[0].Populate("BestFriend").One(friend), ShouldBeNil)
+		So(friend.Name, ShouldEqual, "m")
+		So(friend.GetConn(), ShouldEqual, other)
+
+		populated, err := PopulateAll(found, "BestFriend")
+		So(err, ShouldBeNil)
+		So(len(populated["BestFriend"].Docs), ShouldEqual, 1)
+
+		Convey("in the database and collection they are bound to", func() {
+			a := other.NewDoc(Macao{Name: "archived"}).(*Macao)
+			a.SetDatabase("mogotest")
+			a.SetCollName("macao-archive")
+			So(a.Save(), ShouldBeNil)
+
+			n, _ := conn.Session.DB("mogotest").C("macao-archive").Count()
+			So(n, ShouldEqual, 1)
+
+			loaded := other.NewDoc(Macao{}).(*Macao)
+			So(a.FindID(a.ID).One(loaded), ShouldBeNil)
+			So(loaded.GetColl().Name, ShouldEqual, "macao-archive")
+			So(loaded.Remove(), ShouldBeNil)
+		})
+	})
+}
@@ -8,43 +8,55 @@ import (
 	"github.com/globalsign/mgo/bson"
 )
 
+// ErrVersionConflict is returned by Save when a versioned document was
+// modified (or removed) by someone else after it has been loaded.
+var ErrVersionConflict = errors.New("document version conflict")
+
 // PreSave ...
 func (c *Collection) PreSave(doc Document) error {
-	// Validate?
-	if validator, ok := doc.(ValidateHook); ok {
-		errs := validator.Validate()
-
-		if len(errs) > 0 {
-			return &ValidationError{errs}
-		}
-	}
+	return c.preSave(doc, c.hookContext(c.C(), OpSave))
+}
 
-	if hook, ok := doc.(BeforeSaveHook); ok {
-		err := hook.BeforeSave()
-		if err != nil {
-			return err
-		}
+// preSave runs the validation and before save hooks
+func (c *Collection) preSave(doc Document, hc *HookContext) error {
+	err := runValidate(doc, hc)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return runBeforeSave(doc, hc)
 }
 
 // Save ...
 func (c *Collection) Save(doc Document) error {
-	var err error
-	var cinfo *mgo.ChangeInfo
-
 	sess := c.Connection.Session.Clone()
 	defer sess.Close()
 
 	// Per mgo's recommendation, create a clone of the session so there is no blocking
 	col := c.collectionOnSession(sess)
 
-	err = c.PreSave(doc)
+	op := c.operation(col, OpSave, doc)
+	err := runPipeline(c.Connection, op, func(op *Operation) error {
+		return c.save(col, op.Doc)
+	})
 	if err != nil {
 		return err
 	}
 
+	// We saved it, no longer new
+	if newt, ok := op.Doc.(NewTracker); ok {
+		newt.SetIsNew(false)
+	}
+
+	return nil
+}
+
+// save writes the document, it is the core of the Save pipeline
+// (hooks are run by the pipeline)
+func (c *Collection) save(col *mgo.Collection, doc Document) error {
+	var err error
+	var cinfo *mgo.ChangeInfo
+
 	// If the model implements the NewTracker interface, we'll use that to determine newness. Otherwise always assume it's new
 	isNew := true
 	if newt, ok := doc.(NewTracker); ok {
@@ -61,15 +73,6 @@ func (c *Collection) Save(doc Document) error {
 		tt.SetModified(now)
 	}
 
-	// If the model has indexes we create them here...
-	idxs := doc.GetAllIndex()
-	for i := range idxs {
-		err = col.EnsureIndex(*idxs[i])
-		if err != nil {
-			return err
-		}
-	}
-
 	// go CascadeSave(c, doc)
 
 	id := doc.GetID()
@@ -84,28 +87,75 @@ func (c *Collection) Save(doc Document) error {
 		doc.SetID(id)
 	}
 
-	cinfo, err = col.UpsertId(id, doc)
-	doc.SetCInfo(cinfo)
+	// Versioned documents get the next version, the loaded one is used as precondition
+	vt, versioned := doc.(VersionTracker)
+	if versioned {
+		_, ri, ok := registryOf(doc).Exists(doc)
+		versioned = ok && ri.Versioned
+	}
 
-	if err != nil {
-		return err
+	var version int
+	if versioned {
+		version = vt.GetVersion()
+		vt.SetVersion(version + 1)
 	}
 
-	if hook, ok := doc.(AfterSaveHook); ok {
-		err = hook.AfterSave()
+	// Trackable documents already stored are updated using only the changed fields,
+	// so concurrent writers on other fields are not overwritten with stale data
+	var update interface{} = doc
+	tracker, trackable := doc.(Trackable)
+	if trackable && !isNew {
+		var diff bson.M
+		diff, err = tracker.GetDiffTracker().BsonUpdate()
 		if err != nil {
+			if versioned {
+				vt.SetVersion(version)
+			}
 			return err
 		}
+
+		if diff != nil {
+			update = diff
+		}
 	}
 
-	// We saved it, no longer new
-	if newt, ok := doc.(NewTracker); ok {
-		newt.SetIsNew(false)
+	if versioned && !isNew {
+		err = col.Update(versionSelector(id, version), update)
+		if err == mgo.ErrNotFound {
+			err = ErrVersionConflict
+		} else if err == nil {
+			cinfo = &mgo.ChangeInfo{Matched: 1, Updated: 1}
+		}
+	} else if diff, ok := update.(bson.M); !ok || len(diff) > 0 {
+		// An empty diff means nothing to write (an empty upsert would wipe the document)
+		cinfo, err = col.UpsertId(id, update)
+	}
+	doc.SetCInfo(cinfo)
+
+	if err != nil {
+		if versioned {
+			vt.SetVersion(version)
+		}
+		return err
+	}
+
+	if trackable {
+		tracker.GetDiffTracker().Reset()
 	}
 
 	return nil
 }
 
+// versionSelector matches the document id only if it is still at the passed version.
+// Version 0 is never stored (omitempty), so it matches documents without version.
+func versionSelector(id bson.ObjectId, version int) bson.M {
+	if version == 0 {
+		return bson.M{"_id": id, "_version": bson.M{"$exists": false}}
+	}
+
+	return bson.M{"_id": id, "_version": version}
+}
+
 // Save helper function
 func Save(doc Document) error {
 	return doc.GetColl().Save(doc)
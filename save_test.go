@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -104,3 +105,125 @@ func TestSaveWithChildStruct(t *testing.T) {
 		})
 	})
 }
+
+func TestSaveTrackable(t *testing.T) {
+	conn, _ := Connect(&Config{
+		ConnectionString: "localhost",
+		Database:         "mogotest",
+	})
+	conn.Context.Set("foo", "bar")
+	defer DBConn.Session.Close()
+
+	ModelRegistry.Register(FooChangeTest{})
+
+	Convey("save a trackable document", t, func() {
+		Convey("should update only the changed fields", func() {
+			d := NewDoc(FooChangeTest{
+				StringVal: "foo",
+				IntVal:    1,
+			}).(*FooChangeTest)
+			err := d.Save()
+			So(err, ShouldBeNil)
+
+			// Someone else updates another field in the meantime
+			err = d.GetColl().C().UpdateId(d.ID, bson.M{"$set": bson.M{"intval": 2}})
+			So(err, ShouldBeNil)
+
+			d.StringVal = "bar"
+			err = d.Save()
+			So(err, ShouldBeNil)
+			So(d.GetDiffTracker().Modified("StringVal"), ShouldBeFalse)
+
+			f := NewDoc(FooChangeTest{}).(*FooChangeTest)
+			err = f.FindByID(d.ID, f)
+			So(err, ShouldBeNil)
+			So(f.StringVal, ShouldEqual, "bar")
+			So(f.IntVal, ShouldEqual, 2)
+		})
+
+		Convey("should update the elements of a []T result", func() {
+			for _, v := range []string{"foo", "bar"} {
+				d := NewDoc(FooChangeTest{StringVal: v}).(*FooChangeTest)
+				So(d.Save(), ShouldBeNil)
+			}
+
+			results := []FooChangeTest{}
+			d := NewDoc(FooChangeTest{}).(*FooChangeTest)
+			err := d.Find(bson.M{}).Sort("stringval").All(&results)
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 2)
+
+			results[0].StringVal = "baz"
+			So(results[0].GetDiffTracker().Modified("StringVal"), ShouldBeTrue)
+			So(results[1].GetDiffTracker().Modified("StringVal"), ShouldBeFalse)
+			So(results[0].Save(), ShouldBeNil)
+
+			f := NewDoc(FooChangeTest{}).(*FooChangeTest)
+			err = f.FindByID(results[0].ID, f)
+			So(err, ShouldBeNil)
+			So(f.StringVal, ShouldEqual, "baz")
+		})
+
+		Reset(func() {
+			conn.Session.DB("mogotest").DropDatabase()
+		})
+	})
+}
+
+type versionedDocument struct {
+	DocumentModel `bson:",inline" coll:"versioned-test" version:"true"`
+	Name          string
+}
+
+func TestSaveVersioned(t *testing.T) {
+	conn, _ := Connect(&Config{
+		ConnectionString: "localhost",
+		Database:         "mogotest",
+	})
+	conn.Context.Set("foo", "bar")
+	defer DBConn.Session.Close()
+
+	ModelRegistry.Register(versionedDocument{})
+
+	Convey("save a versioned document", t, func() {
+		Convey("should increment the version on each save", func() {
+			d := NewDoc(versionedDocument{}).(*versionedDocument)
+			err := d.Save()
+			So(err, ShouldBeNil)
+			So(d.Version, ShouldEqual, 1)
+
+			err = d.Save()
+			So(err, ShouldBeNil)
+			So(d.Version, ShouldEqual, 2)
+		})
+
+		Convey("should return ErrVersionConflict on a stale document", func() {
+			d := NewDoc(versionedDocument{Name: "first"}).(*versionedDocument)
+			err := d.Save()
+			So(err, ShouldBeNil)
+
+			stale := NewDoc(versionedDocument{}).(*versionedDocument)
+			err = stale.FindByID(d.ID, stale)
+			So(err, ShouldBeNil)
+
+			d.Name = "second"
+			err = d.Save()
+			So(err, ShouldBeNil)
+
+			stale.Name = "third"
+			err = stale.Save()
+			So(err, ShouldEqual, ErrVersionConflict)
+			So(stale.Version, ShouldEqual, 1)
+
+			f := NewDoc(versionedDocument{}).(*versionedDocument)
+			err = f.FindByID(d.ID, f)
+			So(err, ShouldBeNil)
+			So(f.Name, ShouldEqual, "second")
+			So(f.Version, ShouldEqual, 2)
+		})
+
+		Reset(func() {
+			conn.Session.DB("mogotest").DropDatabase()
+		})
+	})
+}
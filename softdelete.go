@@ -0,0 +1,120 @@
+package mogo
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// ForceRemove removes the document from database even if its model uses soft
+// delete, executing the delete hooks and the ondelete policies
+func (c *Collection) ForceRemove(doc Document) error {
+	// Create a new session per mgo's suggestion to avoid blocking
+	sess := c.Connection.Session.Clone()
+	defer sess.Close()
+
+	return c.remove(sess, doc, true)
+}
+
+// Restore clears the deleted time of a soft deleted document. The update goes
+// through the plugins (OpUpdate) and sets the modified time and the version as
+// UpdateDoc does.
+func (c *Collection) Restore(doc Document) error {
+	sess := c.Connection.Session.Clone()
+	defer sess.Close()
+	col := c.collectionOnSession(sess)
+
+	now := time.Now()
+	op := c.operation(col, OpUpdate, doc)
+	op.Selector = bson.M{"_id": doc.GetID(), "_deleted": bson.M{"$exists": true}}
+	op.Update = stampedUpdate(doc, bson.M{"$unset": bson.M{"_deleted": 1}}, now)
+	err := runPipeline(c.Connection, op, func(op *Operation) error {
+		err := col.Update(op.Selector, op.Update)
+		if err == nil {
+			op.Info = &mgo.ChangeInfo{Matched: 1, Updated: 1}
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	doc.SetCInfo(op.Info)
+	if sd, ok := doc.(SoftDeleteTracker); ok {
+		sd.SetDeleted(nil)
+	}
+	stamped(doc, now)
+
+	return nil
+}
+
+// softRemove sets the deleted time of the document, with the modified time and
+// the version as UpdateDoc does. A document already deleted is not found
+// (mgo.ErrNotFound) as for RemoveId.
+func softRemove(col *mgo.Collection, doc Document) error {
+	now := time.Now()
+
+	err := col.Update(
+		bson.M{"_id": doc.GetID(), "_deleted": bson.M{"$exists": false}},
+		stampedUpdate(doc, bson.M{"$set": bson.M{"_deleted": now}}, now),
+	)
+	if err != nil {
+		return err
+	}
+
+	if sd, ok := doc.(SoftDeleteTracker); ok {
+		sd.SetDeleted(&now)
+	}
+	stamped(doc, now)
+
+	return nil
+}
+
+// stampedUpdate adds to the update of doc the modified time and the version
+// (see stamp), if the model of doc is registered
+func stampedUpdate(doc Document, update bson.M, now time.Time) bson.M {
+	if _, ri, ok := registryOf(doc).Exists(doc); ok {
+		stamp(ri, update, now)
+	}
+
+	return update
+}
+
+// isSoftDelete returns true if the document model uses soft delete
+func isSoftDelete(doc interface{}) bool {
+	_, ri, ok := registryOf(doc).Exists(doc)
+	return ok && ri.SoftDelete
+}
+
+// softDeleted returns true if a soft delete model of reg is stored in the
+// collection
+func softDeleted(reg ModelReg, coll string) bool {
+	for _, ri := range reg.reg() {
+		if ri.Collection == coll && ri.SoftDelete {
+			return true
+		}
+	}
+
+	return false
+}
+
+// softFilter returns the query excluding (or selecting) the soft deleted
+// documents, if a soft delete model of reg is stored in the collection
+func softFilter(reg ModelReg, coll string, query interface{}, mode deletedMode) interface{} {
+	if mode == withDeleted || !softDeleted(reg, coll) {
+		return query
+	}
+
+	return and(query, bson.M{"_deleted": bson.M{"$exists": mode == onlyDeleted}})
+}
+
+// ForceRemove is convenience method for Collection.ForceRemove
+func ForceRemove(doc Document) error {
+	return doc.GetColl().ForceRemove(doc)
+}
+
+// Restore is convenience method for Collection.Restore
+func Restore(doc Document) error {
+	return doc.GetColl().Restore(doc)
+}
@@ -0,0 +1,145 @@
+package mogo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type Note struct {
+	DocumentModel `bson:",inline" coll:"notes" softdelete:"true"`
+	Text          string
+}
+
+type Notebook struct {
+	DocumentModel `bson:",inline" coll:"notebooks"`
+	Notes         RefFieldSlice `ref:"Note"`
+}
+
+func TestSoftDeleteFilter(t *testing.T) {
+	Convey("Soft delete filter", t, func() {
+		ModelRegistry.Register(Note{}, Notebook{})
+
+		q := &Query{MgoC: &mgo.Collection{Name: "notes"}, Query: bson.M{"text": "a"}}
+		So(ModelRegistry.Model("Note").SoftDelete, ShouldBeTrue)
+		So(q.filter(), ShouldResemble, bson.M{"$and": []interface{}{
+			bson.M{"text": "a"},
+			bson.M{"_deleted": bson.M{"$exists": false}},
+		}})
+
+		q.deleted = onlyDeleted
+		So(q.filter(), ShouldResemble, bson.M{"$and": []interface{}{
+			bson.M{"text": "a"},
+			bson.M{"_deleted": bson.M{"$exists": true}},
+		}})
+
+		q.deleted = withDeleted
+		So(q.filter(), ShouldResemble, bson.M{"text": "a"})
+
+		q = &Query{MgoC: &mgo.Collection{Name: "notebooks"}}
+		So(q.filter(), ShouldBeNil)
+	})
+}
+
+func TestSoftDelete(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	ModelRegistry.Register(Note{}, Notebook{})
+
+	Convey("Soft delete", t, func() {
+		conn.Session.DB("mogotest").C("notes").RemoveAll(nil)
+
+		n1 := NewDoc(Note{Text: "a"}).(*Note)
+		n2 := NewDoc(Note{Text: "b"}).(*Note)
+		So(Save(n1), ShouldBeNil)
+		So(Save(n2), ShouldBeNil)
+
+		So(n1.Remove(), ShouldBeNil)
+		So(n1.IsDeleted(), ShouldBeTrue)
+
+		Convey("should keep the document marked as deleted", func() {
+			raw := bson.M{}
+			So(conn.Session.DB("mogotest").C("notes").FindId(n1.ID).One(&raw), ShouldBeNil)
+			So(raw["_deleted"], ShouldNotBeNil)
+
+			So(n1.Remove(), ShouldEqual, mgo.ErrNotFound)
+		})
+
+		Convey("should exclude deleted documents from queries", func() {
+			f := NewDoc(Note{}).(*Note)
+			So(FindID(f, n1.ID).One(f), ShouldEqual, mgo.ErrNotFound)
+			So(FindID(f, n1.ID).WithDeleted().One(f), ShouldBeNil)
+
+			notes := []*Note{}
+			So(Find(f, nil).All(&notes), ShouldBeNil)
+			So(len(notes), ShouldEqual, 1)
+			So(notes[0].ID, ShouldEqual, n2.ID)
+
+			So(Find(f, nil).OnlyDeleted().All(&notes), ShouldBeNil)
+			So(len(notes), ShouldEqual, 1)
+			So(notes[0].ID, ShouldEqual, n1.ID)
+
+			iter := Find(f, nil).Paginate(10).Iter()
+			So(iter.NextPage(&notes), ShouldBeFalse)
+			So(iter.Pagination.T, ShouldEqual, 1)
+		})
+
+		Convey("should exclude deleted documents from populate", func() {
+			nb := NewDoc(Notebook{Notes: RefFieldSlice{{ID: n1.ID}, {ID: n2.ID}}}).(*Notebook)
+			So(Save(nb), ShouldBeNil)
+
+			notes := []*Note{}
+			So(nb.Populate("Notes").All(&notes), ShouldBeNil)
+			So(len(notes), ShouldEqual, 1)
+		})
+
+		Convey("should set the modified time of the deleted document", func() {
+			raw := struct {
+				Modified time.Time `bson:"_modified"`
+				Deleted  time.Time `bson:"_deleted"`
+			}{}
+			So(conn.Session.DB("mogotest").C("notes").FindId(n1.ID).One(&raw), ShouldBeNil)
+			So(raw.Modified.Unix(), ShouldEqual, raw.Deleted.Unix())
+			So(n1.Modified.Unix(), ShouldEqual, raw.Modified.Unix())
+		})
+
+		Convey("should restore a deleted document", func() {
+			ops := []HookOp{}
+			ModelRegistry.Use("Note", func(next Handler) Handler {
+				return func(op *Operation) error {
+					ops = append(ops, op.Hook.Op)
+					return next(op)
+				}
+			})
+			defer func() { ModelRegistry.Model("Note").Plugins = nil }()
+
+			So(n1.Restore(), ShouldBeNil)
+			So(n1.IsDeleted(), ShouldBeFalse)
+			So(ops, ShouldResemble, []HookOp{OpUpdate})
+
+			f := NewDoc(Note{}).(*Note)
+			So(FindID(f, n1.ID).One(f), ShouldBeNil)
+			So(n2.Restore(), ShouldEqual, mgo.ErrNotFound)
+		})
+
+		Convey("should remove the document with ForceRemove", func() {
+			So(ForceRemove(n1), ShouldBeNil)
+			So(ForceRemove(n2), ShouldBeNil)
+
+			c, _ := conn.Session.DB("mogotest").C("notes").Count()
+			So(c, ShouldEqual, 0)
+		})
+
+		Convey("should soft delete with RemoveAll", func() {
+			So(RemoveAll([]Document{n2}), ShouldBeNil)
+
+			c, _ := conn.Session.DB("mogotest").C("notes").Count()
+			So(c, ShouldEqual, 2)
+			So(n2.IsDeleted(), ShouldBeTrue)
+		})
+	})
+}
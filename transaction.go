@@ -0,0 +1,485 @@
+package mogo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/globalsign/mgo/txn"
+)
+
+// DefaultTxnCollection is the journal collection used by WithTransaction
+// when Config.TxnCollection is empty
+const DefaultTxnCollection = "mogo_txn"
+
+// ErrTxAborted is returned by WithTransaction when one of the documents
+// changed (or was removed) by someone else before the commit
+var ErrTxAborted = txn.ErrAborted
+
+// ErrTxClosed is returned when a Tx is used outside its WithTransaction function
+var ErrTxClosed = errors.New("transaction already closed")
+
+// Tx collects the writes made inside Connection.WithTransaction. Writes are
+// queued and applied all together when the function returns without error.
+type Tx struct {
+	conn   *Connection
+	sess   *mgo.Session
+	ops    []*txOp
+	closed bool
+}
+
+// txOp is a queued write and the state needed to undo it
+type txOp struct {
+	doc    Document
+	remove bool
+
+	// prepared state
+	isNew     bool
+	exists    bool
+	versioned bool
+	version   int
+	soft      bool
+	deleted   time.Time
+}
+
+// txKey identifies a document in the transaction
+type txKey struct {
+	coll string
+	id   bson.ObjectId
+}
+
+// WithTransaction runs fn and commits the writes issued through tx as one unit,
+// using a two-phase-commit journal (mgo/txn) stored in Config.TxnCollection.
+// If fn returns an error nothing is written.
+//
+// Hooks are deferred until commit: validation and before hooks run first and
+// any error cancels the transaction; after hooks run once the writes are
+// applied and an error rolls the transaction back, restoring the documents as
+// they were before the commit.
+//
+// The unit covers only the queued documents. The writes don't go through the
+// plugin pipeline (see Use), and the ondelete cascade and nullify policies of
+// removed documents are applied after the commit, outside the transaction: a
+// failure there doesn't roll the transaction back.
+//
+// mgo/txn stores its state in the txn-queue and txn-revno fields of the
+// documents, so a document written in a transaction must never be written
+// outside of one: Save, Remove, Bulk and the update methods don't know about
+// those fields (Save replaces the whole document and drops them), breaking the
+// pending and following transactions on the document.
+func (m *Connection) WithTransaction(fn func(tx *Tx) error) error {
+	sess := m.Session.Clone()
+	defer sess.Close()
+
+	tx := &Tx{conn: m, sess: sess}
+	defer func() {
+		tx.closed = true
+	}()
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.commit()
+}
+
+// WithTransaction is convenience method for DBConn.WithTransaction
+func WithTransaction(fn func(tx *Tx) error) error {
+	return DBConn.WithTransaction(fn)
+}
+
+// Runner returns the mgo/txn runner of the connection journal. It can be used
+// to resume transactions left pending by a crashed process (Runner().ResumeAll()).
+func (m *Connection) Runner() *txn.Runner {
+	return txn.NewRunner(m.Session.DB(m.Config.Database).C(m.txnCollection()))
+}
+
+func (m *Connection) txnCollection() string {
+	if m.Config.TxnCollection != "" {
+		return m.Config.TxnCollection
+	}
+
+	return DefaultTxnCollection
+}
+
+// Save queues the document to be saved on commit
+func (tx *Tx) Save(doc Document) error {
+	return tx.queue(&txOp{doc: doc})
+}
+
+// Remove queues the document to be removed on commit
+func (tx *Tx) Remove(doc Document) error {
+	return tx.queue(&txOp{doc: doc, remove: true})
+}
+
+// RemoveAll queues all passed documents to be removed on commit.
+// Documents in slice can belong to different collections.
+func (tx *Tx) RemoveAll(docs []Document) error {
+	for _, d := range docs {
+		err := tx.Remove(d)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Find is the same as Collection.Find using the transaction session.
+// Queued writes are not visible until commit.
+func (tx *Tx) Find(doc Document, query interface{}) *Query {
+	q := doc.GetColl().Find(query)
+	q.MgoC = doc.GetColl().collectionOnSession(tx.sess)
+	q.rebuild()
+
+	return q
+}
+
+// FindID is the same as Collection.FindID using the transaction session
+func (tx *Tx) FindID(doc Document, id interface{}) *Query {
+	return tx.Find(doc, bson.M{"_id": id})
+}
+
+func (tx *Tx) queue(op *txOp) error {
+	if tx.closed {
+		return ErrTxClosed
+	}
+
+	c := op.doc.GetColl()
+	if c.Database != tx.conn.Config.Database {
+		return fmt.Errorf("collection %s is not in the transaction database %s", c.Name, tx.conn.Config.Database)
+	}
+
+	// A document queued twice for the same write is written once
+	for _, o := range tx.ops {
+		if o.doc == op.doc && o.remove == op.remove {
+			return nil
+		}
+	}
+
+	tx.ops = append(tx.ops, op)
+	return nil
+}
+
+// commit prepares the documents, writes them through the journal and runs the
+// after hooks
+func (tx *Tx) commit() error {
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	for i, op := range tx.ops {
+		err := tx.prepare(op)
+		if err != nil {
+			tx.undo(tx.ops[:i])
+			return err
+		}
+	}
+
+	snapshots, err := tx.snapshots()
+	if err != nil {
+		tx.undo(tx.ops)
+		return err
+	}
+
+	ops := make([]txn.Op, 0, len(tx.ops))
+	for _, op := range tx.ops {
+		o, err := tx.buildOp(op, snapshots)
+		if err != nil {
+			tx.undo(tx.ops)
+			return err
+		}
+		ops = append(ops, o)
+	}
+
+	runner := txn.NewRunner(tx.sess.DB(tx.conn.Config.Database).C(tx.conn.txnCollection()))
+	err = runner.Run(ops, "", nil)
+	if err != nil {
+		tx.undo(tx.ops)
+		return err
+	}
+
+	for _, op := range tx.ops {
+		err = tx.after(op)
+		if err != nil {
+			rerr := tx.rollback(runner, snapshots)
+			tx.undo(tx.ops)
+			if rerr != nil {
+				return fmt.Errorf("%v (rollback failed: %v)", err, rerr)
+			}
+			return err
+		}
+	}
+
+	for _, op := range tx.ops {
+		if op.remove && op.soft {
+			if sd, ok := op.doc.(SoftDeleteTracker); ok {
+				sd.SetDeleted(&op.deleted)
+			}
+			stamped(op.doc, op.deleted)
+			continue
+		}
+
+		if op.remove {
+			err = applyOnDelete(tx.sess, op.doc)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		op.doc.SetCInfo(&mgo.ChangeInfo{Matched: 1, Updated: 1})
+		if tracker, ok := op.doc.(Trackable); ok {
+			tracker.GetDiffTracker().Reset()
+		}
+		if newt, ok := op.doc.(NewTracker); ok {
+			newt.SetIsNew(false)
+		}
+	}
+
+	return nil
+}
+
+// prepare runs validation and before hooks and sets ids, times and versions
+// as Collection.Save and Collection.Remove do
+func (tx *Tx) prepare(op *txOp) error {
+	doc := op.doc
+	c := doc.GetColl()
+	if op.remove {
+		err := runBeforeDelete(doc, tx.hookContext(c, OpRemove))
+		if err != nil {
+			return err
+		}
+
+		op.soft = isSoftDelete(doc)
+		return checkRestrict(tx.sess, doc, !op.soft)
+	}
+
+	err := c.preSave(doc, tx.hookContext(c, OpSave))
+	if err != nil {
+		return err
+	}
+
+	op.isNew = true
+	if newt, ok := doc.(NewTracker); ok {
+		op.isNew = newt.IsNew()
+	}
+
+	now := time.Now()
+	if tt, ok := doc.(TimeCreatedTracker); ok && op.isNew {
+		tt.SetCreated(now)
+	}
+
+	if tt, ok := doc.(TimeModifiedTracker); ok {
+		tt.SetModified(now)
+	}
+
+	id := doc.GetID()
+	if !op.isNew && !id.Valid() {
+		return errors.New("New tracker says this document isn't new but there is no valid Id field")
+	}
+
+	if op.isNew && !id.Valid() {
+		doc.SetID(bson.NewObjectId())
+	}
+
+	vt, versioned := doc.(VersionTracker)
+	if versioned {
+		_, ri, ok := registryOf(doc).Exists(doc)
+		op.versioned = ok && ri.Versioned
+	}
+
+	if op.versioned {
+		op.version = vt.GetVersion()
+		vt.SetVersion(op.version + 1)
+	}
+
+	return nil
+}
+
+// undo restores the versions of the prepared documents
+func (tx *Tx) undo(ops []*txOp) {
+	for _, op := range ops {
+		if op.versioned {
+			op.doc.(VersionTracker).SetVersion(op.version)
+		}
+	}
+}
+
+// snapshots loads the stored version of every document in the transaction,
+// a nil value means that the document doesn't exist
+func (tx *Tx) snapshots() (map[txKey]bson.M, error) {
+	snaps := make(map[txKey]bson.M)
+
+	for _, op := range tx.ops {
+		k := txKey{op.doc.GetColl().Name, op.doc.GetID()}
+		if _, ok := snaps[k]; ok {
+			continue
+		}
+
+		var stored bson.M
+		err := tx.sess.DB(tx.conn.Config.Database).C(k.coll).FindId(k.id).One(&stored)
+		if err != nil && err != mgo.ErrNotFound {
+			return nil, err
+		}
+
+		snaps[k] = stored
+	}
+
+	for _, op := range tx.ops {
+		op.exists = snaps[txKey{op.doc.GetColl().Name, op.doc.GetID()}] != nil
+	}
+
+	return snaps, nil
+}
+
+// buildOp makes the journal operation for the queued write. Stored documents are
+// asserted unchanged (version) so concurrent writers abort the transaction.
+func (tx *Tx) buildOp(op *txOp, snapshots map[txKey]bson.M) (txn.Op, error) {
+	k := txKey{op.doc.GetColl().Name, op.doc.GetID()}
+	o := txn.Op{C: k.coll, Id: k.id}
+
+	if op.remove && op.soft {
+		op.deleted = time.Now()
+		o.Assert = bson.M{"_deleted": bson.M{"$exists": false}}
+		o.Update = stampedUpdate(op.doc, bson.M{"$set": bson.M{"_deleted": op.deleted}}, op.deleted)
+		return o, nil
+	}
+
+	if op.remove {
+		o.Assert = txn.DocExists
+		o.Remove = true
+		return o, nil
+	}
+
+	if !op.exists {
+		o.Assert = txn.DocMissing
+		o.Insert = op.doc
+		return o, nil
+	}
+
+	o.Assert = txn.DocExists
+	if op.versioned {
+		sel := versionSelector(k.id, op.version)
+		delete(sel, "_id")
+		o.Assert = sel
+	}
+
+	var update bson.M
+	if tracker, ok := op.doc.(Trackable); ok && !op.isNew {
+		diff, err := tracker.GetDiffTracker().BsonUpdate()
+		if err != nil {
+			return o, err
+		}
+		update = diff
+	}
+
+	if update == nil {
+		set, err := toBsonM(op.doc)
+		if err != nil {
+			return o, err
+		}
+		update = replaceUpdate(set, snapshots[k])
+	}
+
+	if len(update) == 0 {
+		// Nothing changed, the operation only asserts the document
+		return o, nil
+	}
+
+	o.Update = update
+	return o, nil
+}
+
+// after runs the after hooks of the committed write
+func (tx *Tx) after(op *txOp) error {
+	c := op.doc.GetColl()
+	if op.remove {
+		return runAfterDelete(op.doc, tx.hookContext(c, OpRemove))
+	}
+
+	return runAfterSave(op.doc, tx.hookContext(c, OpSave))
+}
+
+// hookContext returns the HookContext for an operation of the transaction on c
+func (tx *Tx) hookContext(c *Collection, op HookOp) *HookContext {
+	hc := c.hookContext(c.collectionOnSession(tx.sess), op)
+	hc.Tx = tx
+
+	return hc
+}
+
+// rollback restores the snapshots in a new transaction
+func (tx *Tx) rollback(runner *txn.Runner, snapshots map[txKey]bson.M) error {
+	ops := []txn.Op{}
+
+	for k, snap := range snapshots {
+		var current bson.M
+		err := tx.sess.DB(tx.conn.Config.Database).C(k.coll).FindId(k.id).One(&current)
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+
+		switch {
+		case snap == nil && current == nil:
+		case snap == nil:
+			ops = append(ops, txn.Op{C: k.coll, Id: k.id, Remove: true})
+		case current == nil:
+			ops = append(ops, txn.Op{C: k.coll, Id: k.id, Insert: stripTxnFields(snap)})
+		default:
+			if update := replaceUpdate(snap, current); len(update) > 0 {
+				ops = append(ops, txn.Op{C: k.coll, Id: k.id, Update: update})
+			}
+		}
+	}
+
+	if len(ops) == 0 {
+		return nil
+	}
+
+	return runner.Run(ops, "", nil)
+}
+
+// replaceUpdate returns the update replacing the stored document with doc:
+// all doc fields are set and the stored ones missing in doc are unset
+func replaceUpdate(doc bson.M, stored bson.M) bson.M {
+	set := stripTxnFields(doc)
+	delete(set, "_id")
+
+	unset := bson.M{}
+	for k := range stored {
+		if _, ok := set[k]; !ok && k != "_id" && !isTxnField(k) {
+			unset[k] = 1
+		}
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	return update
+}
+
+// stripTxnFields returns a copy of m without the fields used by the journal
+func stripTxnFields(m bson.M) bson.M {
+	c := make(bson.M, len(m))
+	for k, v := range m {
+		if !isTxnField(k) {
+			c[k] = v
+		}
+	}
+
+	return c
+}
+
+func isTxnField(name string) bool {
+	return strings.HasPrefix(name, "txn-")
+}
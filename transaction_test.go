@@ -0,0 +1,151 @@
+package mogo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type Account struct {
+	DocumentModel `bson:",inline" coll:"accounts" version:"true"`
+	Owner         string
+	Balance       int
+
+	failAfterSave bool
+	saved         int
+}
+
+func (a *Account) Validate() []error {
+	if a.Balance < 0 {
+		return []error{errors.New("negative balance")}
+	}
+	return nil
+}
+
+func (a *Account) AfterSave() error {
+	if a.failAfterSave {
+		return errors.New("after save failed")
+	}
+	a.saved++
+	return nil
+}
+
+func TestTransaction(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	ModelRegistry.Register(Account{})
+
+	Convey("Transactions", t, func() {
+		a := NewDoc(Account{Owner: "a", Balance: 100}).(*Account)
+		b := NewDoc(Account{Owner: "b", Balance: 0}).(*Account)
+		So(Save(a), ShouldBeNil)
+		So(Save(b), ShouldBeNil)
+		a.saved, b.saved = 0, 0
+
+		load := func(id bson.ObjectId) *Account {
+			acc := NewDoc(Account{}).(*Account)
+			So(FindID(acc, id).One(acc), ShouldBeNil)
+			return acc
+		}
+
+		Convey("should commit all writes and run after hooks on commit", func() {
+			c := NewDoc(Account{Owner: "c", Balance: 10}).(*Account)
+			err := conn.WithTransaction(func(tx *Tx) error {
+				a.Balance -= 10
+				b.Balance += 10
+				So(tx.Save(a), ShouldBeNil)
+				So(tx.Save(b), ShouldBeNil)
+				So(tx.Save(c), ShouldBeNil)
+
+				// Nothing is written before commit
+				So(a.saved, ShouldEqual, 0)
+				n, _ := tx.FindID(a, a.ID).Q().Count()
+				So(n, ShouldEqual, 1)
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(a.saved, ShouldEqual, 1)
+			So(c.IsNew(), ShouldBeFalse)
+			So(load(a.ID).Balance, ShouldEqual, 90)
+			So(load(b.ID).Balance, ShouldEqual, 10)
+			So(load(c.ID).Owner, ShouldEqual, "c")
+			So(load(a.ID).GetVersion(), ShouldEqual, 2)
+		})
+
+		Convey("should not write anything if the function fails", func() {
+			err := conn.WithTransaction(func(tx *Tx) error {
+				a.Balance = 0
+				So(tx.Save(a), ShouldBeNil)
+				return errors.New("nope")
+			})
+			So(err.Error(), ShouldEqual, "nope")
+			So(load(a.ID).Balance, ShouldEqual, 100)
+		})
+
+		Convey("should not write anything if validation fails", func() {
+			err := conn.WithTransaction(func(tx *Tx) error {
+				a.Balance = 50
+				b.Balance = -50
+				tx.Save(a)
+				return tx.Save(b)
+			})
+			So(err, ShouldHaveSameTypeAs, &ValidationError{})
+			So(load(a.ID).Balance, ShouldEqual, 100)
+			So(a.GetVersion(), ShouldEqual, 1)
+		})
+
+		Convey("should roll back when an after hook fails", func() {
+			c := NewDoc(Account{Owner: "c"}).(*Account)
+			err := conn.WithTransaction(func(tx *Tx) error {
+				a.Balance = 1
+				b.failAfterSave = true
+				tx.Save(a)
+				tx.Save(c)
+				tx.Save(b)
+				return tx.Remove(load(a.ID))
+			})
+			So(err.Error(), ShouldEqual, "after save failed")
+			So(load(a.ID).Balance, ShouldEqual, 100)
+			So(load(a.ID).GetVersion(), ShouldEqual, 1)
+			n, _ := c.GetColl().C().FindId(c.ID).Count()
+			So(n, ShouldEqual, 0)
+		})
+
+		Convey("should abort when a document was changed by someone else", func() {
+			other := load(a.ID)
+			other.Balance = 5
+			So(Save(other), ShouldBeNil)
+
+			err := conn.WithTransaction(func(tx *Tx) error {
+				a.Balance = 0
+				b.Balance = 100
+				tx.Save(b)
+				return tx.Save(a)
+			})
+			So(err, ShouldEqual, ErrTxAborted)
+			So(load(a.ID).Balance, ShouldEqual, 5)
+			So(load(b.ID).Balance, ShouldEqual, 0)
+		})
+
+		Convey("should remove documents", func() {
+			err := conn.WithTransaction(func(tx *Tx) error {
+				return tx.RemoveAll([]Document{a, b})
+			})
+			So(err, ShouldBeNil)
+			n, _ := a.GetColl().C().FindId(bson.M{"$in": []bson.ObjectId{a.ID, b.ID}}).Count()
+			So(n, ShouldEqual, 0)
+		})
+
+		Convey("should not accept writes after the function returns", func() {
+			var tx *Tx
+			conn.WithTransaction(func(t *Tx) error {
+				tx = t
+				return nil
+			})
+			So(tx.Save(a), ShouldEqual, ErrTxClosed)
+		})
+	})
+}
@@ -0,0 +1,319 @@
+package mogo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// Updater is an atomic update operator applied to a field of a model (see Inc,
+// Push, Pull, AddToSet, Min, Max and CurrentDate). Field is the struct field
+// name, or a dotted path, converted to the bson one as Criteria does (see
+// GetBsonPath).
+type Updater struct {
+	Op    string
+	Field string
+	Value interface{}
+}
+
+// Inc increments the field by n ($inc)
+func Inc(field string, n interface{}) Updater {
+	return Updater{Op: "$inc", Field: field, Value: n}
+}
+
+// Push appends the values to the array field ($push, with $each for more
+// than one value)
+func Push(field string, values ...interface{}) Updater {
+	return Updater{Op: "$push", Field: field, Value: each(values)}
+}
+
+// Pull removes from the array field the items equal to value or, if value is
+// a condition (i.e. bson.M{"$gte": 6}), the items matching it ($pull)
+func Pull(field string, value interface{}) Updater {
+	return Updater{Op: "$pull", Field: field, Value: value}
+}
+
+// AddToSet appends to the array field the values it doesn't contain ($addToSet,
+// with $each for more than one value)
+func AddToSet(field string, values ...interface{}) Updater {
+	return Updater{Op: "$addToSet", Field: field, Value: each(values)}
+}
+
+// Min sets the field to value if value is less than the stored one ($min)
+func Min(field string, value interface{}) Updater {
+	return Updater{Op: "$min", Field: field, Value: value}
+}
+
+// Max sets the field to value if value is greater than the stored one ($max)
+func Max(field string, value interface{}) Updater {
+	return Updater{Op: "$max", Field: field, Value: value}
+}
+
+// CurrentDate sets the field to the current date of the server ($currentDate)
+func CurrentDate(field string) Updater {
+	return Updater{Op: "$currentDate", Field: field, Value: true}
+}
+
+// each returns the value of the array operators, the single value or the
+// $each modifier with all the values
+func each(values []interface{}) interface{} {
+	if len(values) == 1 {
+		return values[0]
+	}
+
+	return bson.M{"$each": values}
+}
+
+// updateMode selects the documents changed by an update
+type updateMode int
+
+const (
+	updateOne updateMode = iota
+	updateAll
+	updateAndLoad
+)
+
+// UpdateID applies the updates to the document with the passed id. The
+// collection must store a single registered model. It returns mgo.ErrNotFound
+// if the document doesn't exist (or it is soft deleted).
+func (c *Collection) UpdateID(id interface{}, updates ...Updater) error {
+	_, err := c.update(nil, bson.M{"_id": id}, updates, time.Now(), updateOne)
+
+	return err
+}
+
+// UpdateAll applies the updates to all the documents matching the selector,
+// except the soft deleted ones. The collection must store a single registered
+// model.
+func (c *Collection) UpdateAll(selector interface{}, updates ...Updater) (*mgo.ChangeInfo, error) {
+	return c.update(nil, selector, updates, time.Now(), updateAll)
+}
+
+// UpdateDoc applies the updates to the stored document doc. The modified time
+// (and the version of versioned models) of doc is updated, the other fields
+// are not changed unless refresh is true: doc is then loaded with the updated
+// document returned by the database.
+//
+// The updates don't run the hooks, neither the validation.
+func (c *Collection) UpdateDoc(doc Document, refresh bool, updates ...Updater) error {
+	if !doc.GetID().Valid() {
+		return errors.New("the document to update has no valid Id field")
+	}
+
+	iname, _ := doc.GetMe()
+	b := bindingOf(doc)
+	now := time.Now()
+
+	mode := updateOne
+	if refresh {
+		mode = updateAndLoad
+	}
+
+	_, err := c.update(doc, bson.M{"_id": doc.GetID()}, updates, now, mode)
+	if err != nil {
+		return err
+	}
+
+	if refresh {
+		doc.SetMe(iname, doc)
+		b.apply(doc)
+		loaded(doc)
+		return nil
+	}
+
+	stamped(doc, now)
+
+	return nil
+}
+
+// update applies the updates to the documents matching selector through the
+// plugin pipeline, doc is the updated document (nil if unknown) and it is
+// loaded with the updated one in updateAndLoad mode
+func (c *Collection) update(doc Document, selector interface{}, updates []Updater, now time.Time, mode updateMode) (*mgo.ChangeInfo, error) {
+	sess := c.Connection.Session.Clone()
+	defer sess.Close()
+	col := c.collectionOnSession(sess)
+
+	op := c.operation(col, OpUpdate, doc)
+	reg := c.Connection.Models()
+	_, ri, ok := reg.ExistsByName(op.Model)
+	if !ok {
+		return nil, fmt.Errorf("the collection %s doesn't store a single registered model", c.Name)
+	}
+
+	update, err := buildUpdate(ri, updates, now)
+	if err != nil {
+		return nil, fmt.Errorf("%s (model: %s)", err.Error(), op.Model)
+	}
+
+	op.Selector = softFilter(reg, c.Name, selector, excludeDeleted)
+	op.Update = update
+	err = runPipeline(c.Connection, op, func(op *Operation) error {
+		var err error
+
+		switch mode {
+		case updateAll:
+			op.Info, err = col.UpdateAll(op.Selector, op.Update)
+		case updateAndLoad:
+			op.Info, err = col.Find(op.Selector).Apply(mgo.Change{Update: op.Update, ReturnNew: true}, op.Doc)
+		default:
+			err = col.Update(op.Selector, op.Update)
+			if err == nil {
+				op.Info = &mgo.ChangeInfo{Matched: 1, Updated: 1}
+			}
+		}
+
+		return err
+	})
+
+	if op.Doc != nil {
+		op.Doc.SetCInfo(op.Info)
+	}
+
+	return op.Info, err
+}
+
+var timeModifiedType = reflect.TypeOf((*TimeModifiedTracker)(nil)).Elem()
+
+// buildUpdate returns the update document of the updates for the model ri.
+// The modified time is set to now and the version of versioned models is
+// incremented.
+func buildUpdate(ri *ModelInternals, updates []Updater, now time.Time) (bson.M, error) {
+	if len(updates) == 0 {
+		return nil, errors.New("no update operator")
+	}
+
+	update := bson.M{}
+	for _, u := range updates {
+		p, err := GetBsonPath(ri.Type, u.Field)
+		if err != nil {
+			return nil, err
+		}
+		setOp(update, u.Op, p, u.Value)
+	}
+
+	stamp(ri, update, now)
+
+	return update, nil
+}
+
+// setOp sets the field of the op operator in the update document
+func setOp(update bson.M, op string, field string, value interface{}) {
+	var ops bson.M
+	switch v := update[op].(type) {
+	case bson.M:
+		ops = v
+	case map[string]interface{}:
+		ops = v
+	default:
+		ops = bson.M{}
+	}
+
+	ops[field] = value
+	update[op] = ops
+}
+
+// stamp sets in the update document the modified time of the model ri to now,
+// and increments the version of versioned models
+func stamp(ri *ModelInternals, update bson.M, now time.Time) {
+	if reflect.PtrTo(ri.Type).Implements(timeModifiedType) {
+		setOp(update, "$set", "_modified", now)
+	}
+
+	if ri.Versioned {
+		setOp(update, "$inc", "_version", 1)
+	}
+}
+
+// stampedChange returns the update of an Apply change with the modified time
+// and the version of the model of doc (see stamp). The operators of an update
+// document are copied before being stamped, the fields of a replacement are set
+// on the replacement itself.
+func stampedChange(doc Document, update interface{}, now time.Time) interface{} {
+	_, ri, ok := registryOf(doc).Exists(doc)
+	if !ok {
+		return update
+	}
+
+	var m bson.M
+	switch u := update.(type) {
+	case Document:
+		stamped(u, now)
+		return u
+	case bson.M:
+		m = u
+	case map[string]interface{}:
+		m = u
+	default:
+		return update
+	}
+
+	replace := true
+	c := make(bson.M, len(m)+2)
+	for k, v := range m {
+		if strings.HasPrefix(k, "$") {
+			replace = false
+			if ops, ok := v.(bson.M); ok {
+				v = copyM(ops)
+			} else if ops, ok := v.(map[string]interface{}); ok {
+				v = copyM(ops)
+			}
+		}
+		c[k] = v
+	}
+
+	if !replace {
+		stamp(ri, c, now)
+		return c
+	}
+
+	if reflect.PtrTo(ri.Type).Implements(timeModifiedType) {
+		c["_modified"] = now
+	}
+
+	if v, ok := c["_version"].(int); ok && ri.Versioned {
+		c["_version"] = v + 1
+	}
+
+	return c
+}
+
+// copyM returns a shallow copy of m
+func copyM(m bson.M) bson.M {
+	c := make(bson.M, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return c
+}
+
+// stamped updates the modified time and the version of doc as stamp does with
+// the stored document
+func stamped(doc Document, now time.Time) {
+	if tt, ok := doc.(TimeModifiedTracker); ok {
+		tt.SetModified(now)
+	}
+
+	if vt, ok := doc.(VersionTracker); ok {
+		if _, ri, ok := registryOf(doc).Exists(doc); ok && ri.Versioned {
+			vt.SetVersion(vt.GetVersion() + 1)
+		}
+	}
+}
+
+// Update applies the updates to the stored document (see Collection.UpdateDoc)
+func (d *DocumentModel) Update(updates ...Updater) error {
+	return d.GetColl().UpdateDoc(d.me.(Document), false, updates...)
+}
+
+// UpdateAndRefresh applies the updates to the stored document and loads the
+// updated document (see Collection.UpdateDoc)
+func (d *DocumentModel) UpdateAndRefresh(updates ...Updater) error {
+	return d.GetColl().UpdateDoc(d.me.(Document), true, updates...)
+}
@@ -0,0 +1,154 @@
+package mogo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type Post struct {
+	DocumentModel `bson:",inline" coll:"posts" version:"true"`
+	Title         string
+	Views         int      `bson:"views_count"`
+	Tags          []string `bson:"tags"`
+	Best          int
+	Seen          time.Time
+}
+
+func TestBuildUpdate(t *testing.T) {
+	Convey("buildUpdate", t, func() {
+		ModelRegistry.Register(Post{})
+		ri := ModelRegistry.Model("Post")
+		now := time.Now()
+
+		update, err := buildUpdate(ri, []Updater{
+			Inc("Views", 1),
+			Push("Tags", "a"),
+			AddToSet("Tags", "b", "c"),
+			Max("Best", 10),
+			CurrentDate("Seen"),
+		}, now)
+		So(err, ShouldBeNil)
+		So(update, ShouldResemble, bson.M{
+			"$inc":         bson.M{"views_count": 1, "_version": 1},
+			"$push":        bson.M{"tags": "a"},
+			"$addToSet":    bson.M{"tags": bson.M{"$each": []interface{}{"b", "c"}}},
+			"$max":         bson.M{"best": 10},
+			"$currentDate": bson.M{"seen": true},
+			"$set":         bson.M{"_modified": now},
+		})
+
+		_, err = buildUpdate(ri, []Updater{Inc("Likes", 1)}, now)
+		So(err, ShouldNotBeNil)
+
+		_, err = buildUpdate(ri, nil, now)
+		So(err, ShouldNotBeNil)
+
+		update = bson.M{"$set": map[string]interface{}{"title": "a"}}
+		stamp(ri, update, now)
+		So(update, ShouldResemble, bson.M{
+			"$set": bson.M{"title": "a", "_modified": now},
+			"$inc": bson.M{"_version": 1},
+		})
+	})
+
+	Convey("stampedChange", t, func() {
+		ModelRegistry.Register(Post{})
+		p := NewDoc(Post{}).(*Post)
+		now := time.Now()
+
+		set := bson.M{"title": "a"}
+		change := stampedChange(p, bson.M{"$set": set}, now)
+		So(change, ShouldResemble, bson.M{
+			"$set": bson.M{"title": "a", "_modified": now},
+			"$inc": bson.M{"_version": 1},
+		})
+		So(set, ShouldResemble, bson.M{"title": "a"})
+
+		change = stampedChange(p, bson.M{"title": "a", "_version": 2}, now)
+		So(change, ShouldResemble, bson.M{"title": "a", "_version": 3, "_modified": now})
+
+		r := NewDoc(Post{Title: "a"}).(*Post)
+		r.Version = 2
+		So(stampedChange(p, r, now), ShouldEqual, r)
+		So(r.Version, ShouldEqual, 3)
+		So(r.Modified, ShouldResemble, now)
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	conn := getConnection()
+	defer conn.Session.Close()
+
+	ModelRegistry.Register(Post{})
+
+	Convey("Update", t, func() {
+		conn.Session.DB("mogotest").C("posts").DropCollection()
+
+		p := NewDoc(Post{Title: "a", Tags: []string{"x"}, Best: 5}).(*Post)
+		So(Save(p), ShouldBeNil)
+		modified := p.Modified
+
+		load := func() *Post {
+			f := NewDoc(Post{}).(*Post)
+			So(FindID(f, p.ID).One(f), ShouldBeNil)
+			return f
+		}
+
+		Convey("should apply the operators to the stored document", func() {
+			So(p.Update(Inc("Views", 2), Push("Tags", "y"), Min("Best", 3)), ShouldBeNil)
+			So(p.Views, ShouldEqual, 0)
+			So(p.Modified.After(modified), ShouldBeTrue)
+			So(p.Version, ShouldEqual, 2)
+
+			f := load()
+			So(f.Views, ShouldEqual, 2)
+			So(f.Tags, ShouldResemble, []string{"x", "y"})
+			So(f.Best, ShouldEqual, 3)
+			So(f.Version, ShouldEqual, 2)
+
+			p.Title = "b"
+			So(Save(p), ShouldBeNil)
+		})
+
+		Convey("should refresh the document", func() {
+			So(p.UpdateAndRefresh(AddToSet("Tags", "x", "z"), Pull("Tags", "x")), ShouldNotBeNil)
+
+			So(p.UpdateAndRefresh(AddToSet("Tags", "x", "z")), ShouldBeNil)
+			So(p.Tags, ShouldResemble, []string{"x", "z"})
+			So(p.IsNew(), ShouldBeFalse)
+			So(p.GetCInfo().Updated, ShouldEqual, 1)
+
+			iname, me := p.GetMe()
+			So(iname, ShouldEqual, "github.com/goonode/mogo.Post")
+			So(me, ShouldEqual, p)
+		})
+
+		Convey("should set the modified time and the version with Apply", func() {
+			f := NewDoc(Post{}).(*Post)
+			change := mgo.Change{Update: bson.M{"$set": bson.M{"title": "b"}}, ReturnNew: true}
+
+			_, err := f.Find(bson.M{"_id": p.ID}).Apply(change, f)
+			So(err, ShouldBeNil)
+			So(f.Title, ShouldEqual, "b")
+			So(f.Version, ShouldEqual, 2)
+			So(f.Modified, ShouldHappenOnOrAfter, modified.Truncate(time.Millisecond))
+		})
+
+		Convey("should update by id and selector", func() {
+			So(p.GetColl().UpdateID(p.ID, Inc("Views", 1)), ShouldBeNil)
+			So(p.GetColl().UpdateID(bson.NewObjectId(), Inc("Views", 1)), ShouldEqual, mgo.ErrNotFound)
+
+			q := NewDoc(Post{Title: "b"}).(*Post)
+			So(Save(q), ShouldBeNil)
+
+			info, err := p.GetColl().UpdateAll(nil, Inc("Views", 1))
+			So(err, ShouldBeNil)
+			So(info.Updated, ShouldEqual, 2)
+			So(load().Views, ShouldEqual, 2)
+		})
+	})
+}
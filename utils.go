@@ -1,19 +1,13 @@
 package mogo
 
 import (
+	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 	"unicode"
 )
 
-//Lower cases first char of string
-func lowerInitial(str string) string {
-	for i, v := range str {
-		return string(unicode.ToLower(v)) + str[i+1:]
-	}
-	return ""
-}
-
 // GetBsonName ...
 func GetBsonName(field reflect.StructField) string {
 	tag := field.Tag.Get("bson")
@@ -23,7 +17,87 @@ func GetBsonName(field reflect.StructField) string {
 		return tags[0]
 	}
 
-	return lowerInitial(field.Name)
+	// Same rule used by the mgo bson marshaller
+	return strings.ToLower(field.Name)
+}
+
+// GetBsonPath converts a dotted path of struct field names (i.e. HomeAddress.Street)
+// of the passed type to the dotted path of bson names (i.e. homeaddress.street).
+// Slice indexes and map keys are kept as they are. An error is returned if a field
+// does not exist or it is not stored in database.
+func GetBsonPath(t reflect.Type, path string) (string, error) {
+	names := []string{}
+
+	for _, f := range strings.Split(path, ".") {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		switch t.Kind() {
+		case reflect.Map:
+			names = append(names, f)
+			t = t.Elem()
+			continue
+		case reflect.Slice, reflect.Array:
+			t = t.Elem()
+			if _, err := strconv.Atoi(f); err == nil {
+				names = append(names, f)
+				continue
+			}
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+		}
+
+		if t.Kind() != reflect.Struct {
+			return "", fmt.Errorf("field %s not found in path %s (%s is not a struct)", f, path, t)
+		}
+
+		sf, ok := findBsonField(t, f)
+		if !ok {
+			return "", fmt.Errorf("field %s not found in path %s", f, path)
+		}
+
+		names = append(names, GetBsonName(sf))
+		t = sf.Type
+	}
+
+	return strings.Join(names, "."), nil
+}
+
+// findBsonField searches the named field of t, looking also into the inlined structs
+func findBsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		// Skip if not exported
+		if len(sf.PkgPath) > 0 {
+			continue
+		}
+
+		if isInline(sf) && sf.Type.Kind() == reflect.Struct {
+			if f, ok := findBsonField(sf.Type, name); ok {
+				return f, true
+			}
+			continue
+		}
+
+		if sf.Name == name && GetBsonName(sf) != "-" {
+			return sf, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+func isInline(field reflect.StructField) bool {
+	tags := strings.Split(field.Tag.Get("bson"), ",")
+	for _, t := range tags[1:] {
+		if t == "inline" {
+			return true
+		}
+	}
+
+	return false
 }
 
 // ValueOf return the reflect Value of d. In case of slice or map
@@ -4,29 +4,17 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/globalsign/mgo/bson"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
-func TestLowerInitial(t *testing.T) {
-
-	Convey("LowerInitial", t, func() {
-
-		Convey("ValidateRequired()", func() {
-			So(lowerInitial("foo"), ShouldEqual, "foo")
-			So(lowerInitial("Foo"), ShouldEqual, "foo")
-			So(lowerInitial("Abba"), ShouldEqual, "abba")
-			So(lowerInitial("ABBA"), ShouldEqual, "aBBA")
-			So(lowerInitial(""), ShouldEqual, "")
-		})
-
-	})
-}
-
 func TestBsonName(t *testing.T) {
 	Convey("GetBsonName", t, func() {
 		type Model struct {
-			Property  string `bson:"property" json:"property"`
-			Property2 string `json:"property3"`
+			Property    string `bson:"property" json:"property"`
+			Property2   string `json:"property3"`
+			HomeCity    string
+			HomeCityZIP string
 		}
 
 		Convey("GetBsonName(Model)", func() {
@@ -39,7 +27,65 @@ func TestBsonName(t *testing.T) {
 			val2 := reflect.Indirect(reflect.ValueOf(obj))
 			field2, _ := val2.Type().FieldByName("Property2")
 			So(GetBsonName(field2), ShouldEqual, "property2")
+
+			field3, _ := val2.Type().FieldByName("HomeCity")
+			So(GetBsonName(field3), ShouldEqual, "homecity")
+
+			field4, _ := val2.Type().FieldByName("HomeCityZIP")
+			So(GetBsonName(field4), ShouldEqual, "homecityzip")
 		})
 
+		Convey("GetBsonName() should match the name stored by mgo", func() {
+			raw, err := bson.Marshal(Model{})
+			So(err, ShouldBeNil)
+
+			stored := bson.M{}
+			So(bson.Unmarshal(raw, stored), ShouldBeNil)
+
+			typ := reflect.TypeOf(Model{})
+			for i := 0; i < typ.NumField(); i++ {
+				So(stored, ShouldContainKey, GetBsonName(typ.Field(i)))
+			}
+		})
+
+	})
+}
+
+func TestBsonPath(t *testing.T) {
+	Convey("GetBsonPath", t, func() {
+		type Address struct {
+			Zip string `bson:"zip_code"`
+		}
+
+		type Model struct {
+			DocumentModel `bson:",inline"`
+			Addresses     []*Address
+			Tags          map[string]Address
+			Hidden        string `bson:"-"`
+		}
+
+		typ := reflect.TypeOf(Model{})
+
+		p, err := GetBsonPath(typ, "Addresses.2.Zip")
+		So(err, ShouldBeNil)
+		So(p, ShouldEqual, "addresses.2.zip_code")
+
+		p, err = GetBsonPath(typ, "Addresses.Zip")
+		So(err, ShouldBeNil)
+		So(p, ShouldEqual, "addresses.zip_code")
+
+		p, err = GetBsonPath(typ, "Tags.home.Zip")
+		So(err, ShouldBeNil)
+		So(p, ShouldEqual, "tags.home.zip_code")
+
+		p, err = GetBsonPath(typ, "Created")
+		So(err, ShouldBeNil)
+		So(p, ShouldEqual, "_created")
+
+		_, err = GetBsonPath(typ, "Hidden")
+		So(err, ShouldNotBeNil)
+
+		_, err = GetBsonPath(typ, "Addresses.Zip.Code")
+		So(err, ShouldNotBeNil)
 	})
 }
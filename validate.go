@@ -1,7 +1,14 @@
 package mogo
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/globalsign/mgo/bson"
 )
@@ -36,3 +43,396 @@ func stringInSlice(a string, list []string) bool {
 func ValidateInclusionIn(value string, options []string) bool {
 	return stringInSlice(value, options)
 }
+
+// Rules of the validate tag
+const (
+	RuleRequired = "required"
+	RuleMin      = "min"
+	RuleMax      = "max"
+	RuleIn       = "in"
+	RuleRegex    = "regex"
+	RuleEmail    = "email"
+	RuleRef      = "ref"
+
+	// RuleCustom is the rule of the errors returned by the Validate hooks
+	RuleCustom = "custom"
+)
+
+var emailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// FieldError is a validation error. Errors of the validate tag rules refer
+// to a field, the errors returned by the Validate hooks are wrapped with the
+// custom rule.
+type FieldError struct {
+	// Path is the bson path of the field, with indexes for slices (i.e. addresses.2.zip)
+	Path string `json:"path,omitempty"`
+	// Field is the Go path of the field (i.e. Addresses.2.Zip)
+	Field string `json:"field,omitempty"`
+	// Rule is the failed rule (see the Rule* constants)
+	Rule string `json:"rule"`
+	// Message describes the error
+	Message string `json:"message"`
+	// Params are the parameters of the rule (i.e. {"min": 3} for min=3)
+	Params map[string]interface{} `json:"params,omitempty"`
+
+	// Err is the wrapped error of a Validate hook
+	Err error `json:"-"`
+}
+
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+
+	return e.Path + " " + e.Message
+}
+
+// wrapFieldErrors converts the errors returned by the Validate hooks (and
+// the ValidationError ones) to FieldError values
+func wrapFieldErrors(errs []error) []*FieldError {
+	fes := make([]*FieldError, 0, len(errs))
+	for _, err := range errs {
+		if fe, ok := err.(*FieldError); ok {
+			fes = append(fes, fe)
+			continue
+		}
+
+		fes = append(fes, &FieldError{Rule: RuleCustom, Message: err.Error(), Err: err})
+	}
+
+	return fes
+}
+
+// Rule is a parsed rule of the validate tag
+type Rule struct {
+	Name  string
+	Param string
+
+	n       float64
+	options []string
+	re      *regexp.Regexp
+}
+
+// params returns the parameters of the rule reported in FieldError
+func (r *Rule) params() map[string]interface{} {
+	switch r.Name {
+	case RuleMin, RuleMax:
+		return map[string]interface{}{r.Name: r.n}
+	case RuleIn:
+		return map[string]interface{}{r.Name: r.options}
+	case RuleRegex:
+		return map[string]interface{}{r.Name: r.Param}
+	}
+
+	return nil
+}
+
+// FieldRules are the rules of the validate tag of a struct field. Nested are
+// the rules of the fields of the struct value (struct, pointer, slice or map
+// of structs).
+type FieldRules struct {
+	Idx    int
+	Field  string
+	Path   string
+	Rules  []Rule
+	Nested []FieldRules
+}
+
+// structRules parses the validate tags of the struct fields. refs are the ref
+// fields of the model (nil for nested structs), visiting stops recursive types.
+func structRules(t reflect.Type, refs map[string]RefIndex, visiting map[reflect.Type]bool, me *modelErrors) []FieldRules {
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	var rules []FieldRules
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Type.ConvertibleTo(reflect.TypeOf(DocumentModel{})) {
+			continue
+		}
+
+		fr := extractValidate(sf, i, refs, me)
+
+		if et := nestedType(sf.Type); et != nil && sf.PkgPath == "" && !visiting[et] {
+			if nested := structRules(et, nil, visiting, me); len(nested) > 0 {
+				if fr == nil {
+					fr = &FieldRules{Idx: i, Field: sf.Name, Path: GetBsonName(sf)}
+				}
+				fr.Nested = nested
+			}
+		}
+
+		if fr != nil {
+			rules = append(rules, *fr)
+		}
+	}
+
+	return rules
+}
+
+// nestedType returns the struct type validated inside a field value
+func nestedType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct || t.ConvertibleTo(reflect.TypeOf(RefField{})) || t == reflect.TypeOf(time.Time{}) {
+		return nil
+	}
+
+	return t
+}
+
+// extractValidate parses the validate tag of the field. The regex rule uses the
+// remaining part of the tag, so it must be the last one.
+func extractValidate(sf reflect.StructField, idx int, refs map[string]RefIndex, me *modelErrors) *FieldRules {
+	tag := sf.Tag.Get("validate")
+	if tag == "" {
+		return nil
+	}
+
+	if sf.PkgPath != "" {
+		me.add(sf.Name, "validate tag used on an unexported field")
+		return nil
+	}
+
+	fr := &FieldRules{Idx: idx, Field: sf.Name, Path: GetBsonName(sf)}
+
+	for tag != "" {
+		var part string
+		if strings.HasPrefix(tag, RuleRegex+"=") {
+			part, tag = tag, ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			part, tag = tag[:i], tag[i+1:]
+		} else {
+			part, tag = tag, ""
+		}
+
+		r := Rule{Name: strings.TrimSpace(part)}
+		if i := strings.Index(part, "="); i >= 0 {
+			r.Name, r.Param = strings.TrimSpace(part[:i]), part[i+1:]
+		}
+
+		var err error
+		switch r.Name {
+		case RuleRequired, RuleEmail:
+		case RuleMin, RuleMax:
+			r.n, err = strconv.ParseFloat(r.Param, 64)
+		case RuleIn:
+			r.options = strings.Split(r.Param, "|")
+		case RuleRegex:
+			r.re, err = regexp.Compile(r.Param)
+		case RuleRef:
+			if _, ok := refs[sf.Name]; !ok {
+				err = errors.New("not a ref field")
+			}
+		default:
+			err = errors.New("unknown rule")
+		}
+
+		if err != nil {
+			me.add(sf.Name, "Bad validate tag (rule: %s): %v", part, err)
+			continue
+		}
+
+		fr.Rules = append(fr.Rules, r)
+	}
+
+	return fr
+}
+
+// validateFields checks the validate tag rules of the document fields
+func validateFields(doc interface{}, hc *HookContext) []*FieldError {
+	reg := registryOf(doc)
+	_, ri, ok := reg.Exists(doc)
+	if !ok || len(ri.Rules) == 0 {
+		return nil
+	}
+
+	return checkRules(reflect.Indirect(reflect.ValueOf(doc)), ri.Rules, reg, ri.Refs, "", "", hc)
+}
+
+// checkRules checks the rules of the struct value v, path and field are the
+// prefixes of the bson and Go paths of v. reg is the registry of the ref
+// fields refs.
+func checkRules(v reflect.Value, rules []FieldRules, reg ModelReg, refs map[string]RefIndex, path string, field string, hc *HookContext) []*FieldError {
+	errs := []*FieldError{}
+
+	for _, fr := range rules {
+		fv := v.Field(fr.Idx)
+		fpath, ffield := joinPath(path, fr.Path), joinPath(field, fr.Field)
+
+		for _, r := range fr.Rules {
+			msg := r.check(fv, reg, refs[fr.Field], hc)
+			if msg != "" {
+				errs = append(errs, &FieldError{
+					Path:    fpath,
+					Field:   ffield,
+					Rule:    r.Name,
+					Message: msg,
+					Params:  r.params(),
+				})
+			}
+		}
+
+		if fr.Nested != nil {
+			errs = append(errs, checkNested(fv, fr.Nested, fpath, ffield, hc)...)
+		}
+	}
+
+	return errs
+}
+
+// checkNested checks the nested rules of a struct, pointer, slice or map value,
+// slice indexes and map keys are added to the paths
+func checkNested(v reflect.Value, rules []FieldRules, path string, field string, hc *HookContext) []*FieldError {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return checkNested(v.Elem(), rules, path, field, hc)
+	case reflect.Slice, reflect.Array:
+		errs := []*FieldError{}
+		for i := 0; i < v.Len(); i++ {
+			idx := strconv.Itoa(i)
+			errs = append(errs, checkNested(v.Index(i), rules, joinPath(path, idx), joinPath(field, idx), hc)...)
+		}
+		return errs
+	case reflect.Map:
+		errs := []*FieldError{}
+		for _, k := range v.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			errs = append(errs, checkNested(v.MapIndex(k), rules, joinPath(path, key), joinPath(field, key), hc)...)
+		}
+		return errs
+	case reflect.Struct:
+		return checkRules(v, rules, nil, nil, path, field, hc)
+	}
+
+	return nil
+}
+
+func joinPath(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+
+	return prefix + "." + name
+}
+
+// check returns the error message if the value does not satisfy the rule.
+// Empty values are checked only by the required rule.
+func (r *Rule) check(v reflect.Value, reg ModelReg, ref RefIndex, hc *HookContext) string {
+	empty := isEmptyValue(v)
+	if r.Name == RuleRequired {
+		if empty {
+			return "is required"
+		}
+		return ""
+	}
+
+	if empty {
+		return ""
+	}
+
+	v = reflect.Indirect(v)
+	switch r.Name {
+	case RuleMin, RuleMax:
+		n, isLen := measure(v)
+		if r.Name == RuleMin && n < r.n {
+			if isLen {
+				return "length must be at least " + r.Param
+			}
+			return "must be at least " + r.Param
+		}
+		if r.Name == RuleMax && n > r.n {
+			if isLen {
+				return "length must be at most " + r.Param
+			}
+			return "must be at most " + r.Param
+		}
+	case RuleIn:
+		if !stringInSlice(fmt.Sprint(v.Interface()), r.options) {
+			return "must be one of " + strings.Join(r.options, ", ")
+		}
+	case RuleRegex:
+		if v.Kind() != reflect.String || !r.re.MatchString(v.String()) {
+			return "must match " + r.Param
+		}
+	case RuleEmail:
+		if v.Kind() != reflect.String || !emailRegexp.MatchString(v.String()) {
+			return "must be a valid email address"
+		}
+	case RuleRef:
+		if !refsExist(v, reg, ref, hc) {
+			return "refers to a missing document"
+		}
+	}
+
+	return ""
+}
+
+// isEmptyValue reports if v is the zero value, an empty slice or map, or
+// a ref without id
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+
+	if rf, ok := v.Interface().(RefField); ok {
+		return rf.ID == ""
+	}
+
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// measure returns the length of strings, slices and maps or the numeric value
+func measure(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false
+	}
+
+	return 0, false
+}
+
+// refsExist checks that the documents referenced by the ref field are stored
+func refsExist(v reflect.Value, reg ModelReg, ref RefIndex, hc *HookContext) bool {
+	_, ri, ok := reg.ExistsByName(ref.Ref)
+	if !ok {
+		return false
+	}
+
+	ids := []bson.ObjectId{}
+	seen := map[bson.ObjectId]bool{}
+	for _, id := range refIDs(v) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return true
+	}
+
+	c := DBConn.Collection(ri.Collection).C()
+	if hc != nil && hc.Collection != nil {
+		c = hc.Collection.Database.C(ri.Collection)
+	}
+
+	n, err := c.Find(bson.M{"_id": bson.M{"$in": ids}}).Count()
+	return err == nil && n == len(ids)
+}
@@ -1,19 +1,164 @@
 package mogo
 
 import (
+	"encoding/json"
+	"errors"
 	"testing"
 
 	"github.com/globalsign/mgo/bson"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+type Signup struct {
+	DocumentModel `bson:",inline" coll:"signups"`
+	Name          string   `validate:"required,min=3,max=10"`
+	Email         string   `bson:"mail" validate:"required,email"`
+	Role          string   `validate:"in=admin|user"`
+	Age           int      `validate:"min=18,max=130"`
+	Code          string   `validate:"regex=^[A-Z]{2}[0-9]{1,3}$"`
+	Tags          []string `validate:"max=2"`
+	Inviter       RefField `ref:"Signup" validate:"ref"`
+}
+
+type ZipAddress struct {
+	Street string `validate:"required"`
+	Zip    string `validate:"regex=^[0-9]{5}$"`
+}
+
+type Customer struct {
+	DocumentModel `bson:",inline" coll:"customers"`
+	Main          *ZipAddress
+	Addresses     []ZipAddress `bson:"addrs" validate:"max=3"`
+	ByName        map[string]*ZipAddress
+}
+
+func (c *Customer) Validate() []error {
+	if c.Main == nil {
+		return []error{errors.New("main address missing")}
+	}
+	return nil
+}
+
+func TestValidateTag(t *testing.T) {
+	Convey("Validate tag", t, func() {
+		ModelRegistry.Register(Signup{})
+
+		Convey("should be parsed once by the registry", func() {
+			rules := ModelRegistry.Model("Signup").Rules
+			So(len(rules), ShouldEqual, 7)
+			So(rules[1].Path, ShouldEqual, "mail")
+			So(rules[0].Rules, ShouldResemble, []Rule{
+				{Name: RuleRequired},
+				{Name: RuleMin, Param: "3", n: 3},
+				{Name: RuleMax, Param: "10", n: 10},
+			})
+			So(rules[4].Rules[0].Param, ShouldEqual, "^[A-Z]{2}[0-9]{1,3}$")
+		})
+
+		Convey("should panic on bad rules", func() {
+			type BadRule struct {
+				DocumentModel `bson:",inline" coll:"bad"`
+				Name          string `validate:"size=3"`
+			}
+			type BadRef struct {
+				DocumentModel `bson:",inline" coll:"bad"`
+				Name          string `validate:"ref"`
+			}
+			type BadMin struct {
+				DocumentModel `bson:",inline" coll:"bad"`
+				Name          string `validate:"min=a"`
+			}
+			So(func() { ModelRegistry.Register(BadRule{}) }, ShouldPanic)
+			So(func() { ModelRegistry.Register(BadRef{}) }, ShouldPanic)
+			So(func() { ModelRegistry.Register(BadMin{}) }, ShouldPanic)
+		})
+
+		Convey("should check the rules", func() {
+			s := &Signup{Name: "a very long name", Email: "foo", Role: "root", Age: 3, Code: "A1", Tags: []string{"a", "b", "c"}}
+			errs := validateFields(s, nil)
+			So(len(errs), ShouldEqual, 6)
+
+			paths := []string{}
+			for _, e := range errs {
+				paths = append(paths, e.Path+":"+e.Rule)
+			}
+			So(paths, ShouldResemble, []string{"name:max", "mail:email", "role:in", "age:min", "code:regex", "tags:max"})
+			So(errs[0].Error(), ShouldEqual, "name length must be at most 10")
+
+			s = &Signup{Name: "Bob", Email: "bob@example.com", Role: "user", Age: 20, Code: "AB12"}
+			So(validateFields(s, nil), ShouldBeEmpty)
+
+			errs = validateFields(&Signup{}, nil)
+			So(len(errs), ShouldEqual, 2)
+			So(errs[0].Rule, ShouldEqual, RuleRequired)
+		})
+
+		Convey("should check nested structs with indexed paths", func() {
+			ModelRegistry.Register(Customer{})
+
+			c := &Customer{
+				Main:      &ZipAddress{Zip: "12345"},
+				Addresses: []ZipAddress{{"a", "12345"}, {"b", ""}, {"c", "abc"}},
+				ByName:    map[string]*ZipAddress{"home": {Street: "d", Zip: "1"}},
+			}
+			errs := validateFields(c, nil)
+			So(len(errs), ShouldEqual, 3)
+			So(*errs[0], ShouldResemble, FieldError{Path: "main.street", Field: "Main.Street", Rule: RuleRequired, Message: "is required"})
+			So(errs[1].Path, ShouldEqual, "addrs.2.zip")
+			So(errs[1].Field, ShouldEqual, "Addresses.2.Zip")
+			So(errs[1].Params, ShouldResemble, map[string]interface{}{"regex": "^[0-9]{5}$"})
+			So(errs[2].Path, ShouldEqual, "byname.home.zip")
+		})
+
+		Convey("should wrap the Validate hook errors and render JSON", func() {
+			ModelRegistry.Register(Customer{})
+
+			err := runValidate(&Customer{Addresses: []ZipAddress{{}, {}, {}, {}}}, nil)
+			v, ok := err.(*ValidationError)
+			So(ok, ShouldBeTrue)
+			So(len(v.Errors), ShouldEqual, 6)
+			So(v.Errors[0], ShouldHaveSameTypeAs, &FieldError{})
+
+			fields := v.Fields()
+			So(fields[0].Path, ShouldEqual, "addrs")
+			So(fields[0].Params, ShouldResemble, map[string]interface{}{"max": float64(3)})
+			So(fields[5].Rule, ShouldEqual, RuleCustom)
+			So(fields[5].Error(), ShouldEqual, "main address missing")
+
+			v.Errors = v.Errors[4:]
+			b, err := json.Marshal(v)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, `{"error":"validation failed","errors":[`+
+				`{"path":"addrs.3.street","field":"Addresses.3.Street","rule":"required","message":"is required"},`+
+				`{"rule":"custom","message":"main address missing"}]}`)
+		})
+	})
+}
+
 func TestValidation(t *testing.T) {
 	connection := getConnection()
 	defer connection.Session.Close()
 
-	ModelRegistry.Register(noHookDocument{}, hookedDocument{})
+	ModelRegistry.Register(noHookDocument{}, hookedDocument{}, Signup{})
 
 	Convey("Validation", t, func() {
+		Convey("Save should run the validate tag rules", func() {
+			s := NewDoc(Signup{Name: "Bob", Email: "bob@example.com"}).(*Signup)
+			s.Inviter = RefField{ID: bson.NewObjectId()}
+
+			err := Save(s)
+			v, ok := err.(*ValidationError)
+			So(ok, ShouldBeTrue)
+			So(v.Fields()[0].Path, ShouldEqual, "inviter")
+			So(v.Fields()[0].Rule, ShouldEqual, RuleRef)
+
+			s.Inviter = RefField{}
+			So(Save(s), ShouldBeNil)
+
+			s2 := NewDoc(Signup{Name: "Alice", Email: "alice@example.com", Inviter: RefField{ID: s.ID}}).(*Signup)
+			So(Save(s2), ShouldBeNil)
+		})
+
 		Convey("ValidateRequired()", func() {
 			So(ValidateRequired("foo"), ShouldEqual, true)
 			So(ValidateRequired(""), ShouldEqual, false)